@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"github.com/exiledavatar/cmsrvu/cmsrvu"
+	"github.com/spf13/cobra"
+)
+
+// loadCmd downloads every configured release and loads it into the db
+var loadCmd = &cobra.Command{
+	Use:   "load",
+	Short: "Download the configured RVU releases and load them into the db",
+	Long: `Download the configured RVU releases and load them into the db.
+
+Every attempt is recorded in the load_log table. Releases that were already
+loaded successfully from an identical archive are skipped unless --force is set.
+With --gpci, --locco, --anes and --oppscap the GPCI, locality/county crosswalk,
+anesthesia conversion factor and OPPS payment cap files in each archive are loaded
+into the gpci, locality_county, anesthesia_cf and opps_cap tables too. With --zip the ZIP code to carrier locality archives
+in the config's ZIPData are loaded into the zip_locality table.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config()
+		if err != nil {
+			return err
+		}
+		db, err := connect(cfg)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		flags := cmd.Flags()
+		force, _ := flags.GetBool("force")
+		schema, table, loadLog, err := tableNames(cmd, cfg)
+		if err != nil {
+			return err
+		}
+		mode := cfg.DB.LoadMode
+		if m, _ := flags.GetString("mode"); m != "" {
+			mode = cmsrvu.LoadMode(m)
+		}
+
+		partitioned, _ := flags.GetBool("partitioned")
+
+		loader := cmsrvu.Loader{
+			DB:          db,
+			Schema:      schema,
+			Table:       table,
+			LoadLog:     loadLog,
+			Mode:        mode,
+			Partitioned: partitioned || cfg.DB.Partitioned,
+			Force:       force,
+		}
+		if gpci, _ := flags.GetBool("gpci"); gpci {
+			if loader.GPCITable, err = cfg.DB.TableName("gpci"); err != nil {
+				return err
+			}
+		}
+		if locco, _ := flags.GetBool("locco"); locco {
+			if loader.LocalityCountyTable, err = cfg.DB.TableName("locality_county"); err != nil {
+				return err
+			}
+		}
+		if anes, _ := flags.GetBool("anes"); anes {
+			if loader.AnesthesiaCFTable, err = cfg.DB.TableName("anesthesia_cf"); err != nil {
+				return err
+			}
+		}
+		if oppscap, _ := flags.GetBool("oppscap"); oppscap {
+			if loader.OPPSCapTable, err = cfg.DB.TableName("opps_cap"); err != nil {
+				return err
+			}
+		}
+		if zip, _ := flags.GetBool("zip"); zip {
+			if loader.ZIPLocalityTable, err = cfg.DB.TableName("zip_locality"); err != nil {
+				return err
+			}
+		}
+		if dir, _ := flags.GetString("csv-dir"); dir != "" {
+			loader.Sinks = append(loader.Sinks, &cmsrvu.FileSink{Dir: dir, Name: table})
+		}
+		if dir, _ := flags.GetString("parquet-dir"); dir != "" {
+			loader.Sinks = append(loader.Sinks, &cmsrvu.ParquetSink{Dir: dir, Name: table})
+		}
+		ctx := cmd.Context()
+		if err := loader.Setup(ctx); err != nil {
+			return err
+		}
+		return loader.Load(ctx, *cfg)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(loadCmd)
+	loadCmd.Flags().Bool("force", false, "reload releases even if they were already loaded")
+	loadCmd.Flags().String("mode", "", "load mode: insert or upsert (defaults to the config's DB.LoadMode)")
+	loadCmd.Flags().Bool("partitioned", false, "create the table partitioned by year of effective date (postgres only)")
+	loadCmd.Flags().Bool("gpci", false, "also load each release's GPCI file into the gpci table")
+	loadCmd.Flags().Bool("locco", false, "also load each release's locality/county crosswalk into the locality_county table")
+	loadCmd.Flags().Bool("anes", false, "also load each release's anesthesia conversion factors into the anesthesia_cf table")
+	loadCmd.Flags().Bool("oppscap", false, "also load each release's OPPS payment caps into the opps_cap table")
+	loadCmd.Flags().Bool("zip", false, "also load the configured ZIP code to carrier locality archives (ZIPData) into the zip_locality table")
+	loadCmd.Flags().String("csv-dir", "", "also export each release as csv to this directory")
+	loadCmd.Flags().String("parquet-dir", "", "also export each release as parquet to this directory, partitioned by effective year and quarter")
+}
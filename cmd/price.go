@@ -0,0 +1,277 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/exiledavatar/cmsrvu/cmsrvu"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jmoiron/sqlx"
+	"github.com/spf13/cobra"
+)
+
+// priceCmd prices a service in a locality from the loaded rvus and GPCIs
+var priceCmd = &cobra.Command{
+	Use:   "price",
+	Short: "Price a service in a locality from the rvus and GPCIs in the db",
+	Long: `Price a service in a locality from the rvus and GPCIs in the db:
+
+  [(work rvu x work gpci) + (pe rvu x pe gpci) + (mp rvu x mp gpci)] x cf
+
+using the releases in effect on --date. The GPCIs have to have been loaded with
+load --gpci. The locality is either --locality, the contractor and locality
+number ie 01182-18, or looked up from --zip in the zip_locality table (load --zip).
+Both the facility and non-facility amounts are printed, NA if the code's NA
+indicator is set for the setting. Codes whose calculation flag says the OPPS cap
+applies are capped at their OPPS payment amount from the opps_cap table (load
+--oppscap), --oppscap=false prices them uncapped.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config()
+		if err != nil {
+			return err
+		}
+		flags := cmd.Flags()
+		hcpcs, _ := flags.GetString("hcpcs")
+		modifier, _ := flags.GetString("modifier")
+		date, _ := flags.GetString("date")
+		dos := time.Now().UTC()
+		if date != "" {
+			if dos, err = time.Parse(time.DateOnly, date); err != nil {
+				return err
+			}
+		}
+		setting := cmsrvu.SettingNonFacility
+		if s, _ := flags.GetString("setting"); s != "" {
+			if setting, err = cmsrvu.ParseSetting(s); err != nil {
+				return err
+			}
+		}
+		schema, table, _, err := tableNames(cmd, cfg)
+		if err != nil {
+			return err
+		}
+		gpciTable, err := cfg.DB.TableName("gpci")
+		if err != nil {
+			return err
+		}
+
+		db, err := connect(cfg)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		ctx := cmd.Context()
+		dateOfService := pgtype.Date{Time: dos, Valid: true}
+		locality, err := flagLocality(cmd, cfg, db, schema, dos)
+		if err != nil {
+			return err
+		}
+
+		rvus, err := cmsrvu.QueryRVUs(ctx, db, schema, table, cmsrvu.RVUQuery{
+			HCPCS:         []string{hcpcs},
+			DateOfService: dateOfService,
+		})
+		if err != nil {
+			return err
+		}
+		gpcis, err := cmsrvu.QueryGPCIs(ctx, db, schema, gpciTable, dateOfService)
+		if err != nil {
+			return err
+		}
+		pricer := cmsrvu.Pricer{RVUs: cmsrvu.NewRVUIndex(rvus), GPCIs: cmsrvu.NewGPCIIndex(gpcis)}
+		if oppscap, _ := flags.GetBool("oppscap"); oppscap {
+			capTable, err := cfg.DB.TableName("opps_cap")
+			if err != nil {
+				return err
+			}
+			caps, err := cmsrvu.QueryOPPSCaps(ctx, db, schema, capTable, dateOfService, hcpcs)
+			if err != nil {
+				return fmt.Errorf("reading the opps caps (load --oppscap, or price with --oppscap=false): %w", err)
+			}
+			pricer.OPPSCaps = cmsrvu.NewOPPSCapIndex(caps)
+		}
+		pay, err := pricer.Price(hcpcs, modifier, locality, dos, setting)
+		if err != nil {
+			return err
+		}
+
+		na := func(d cmsrvu.Decimal) string {
+			if !d.Valid {
+				return "NA"
+			}
+			return d.String()
+		}
+		w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "hcpcs\t%s %s\n", pay.HCPCS, pay.Modifier)
+		fmt.Fprintf(w, "description\t%s\n", pay.RVU.Description.String)
+		fmt.Fprintf(w, "locality\t%s %s %s\n", pay.Locality, pay.GPCI.State, pay.GPCI.LocalityName)
+		fmt.Fprintf(w, "rvu release\t%s\n", pay.RVU.EffectiveDate.Time.Format(time.DateOnly))
+		fmt.Fprintf(w, "gpci release\t%s\n", pay.GPCI.EffectiveDate.Time.Format(time.DateOnly))
+		capped := func(d cmsrvu.Decimal, capped bool) string {
+			if capped {
+				return na(d) + " (opps cap)"
+			}
+			return na(d)
+		}
+		if pay.OPPSCap != nil {
+			fmt.Fprintf(w, "opps cap\t%s facility, %s non-facility\n", na(pay.OPPSCap.FacilityPrice), na(pay.OPPSCap.NonFacilityPrice))
+		}
+		fmt.Fprintf(w, "facility\t%s\n", capped(pay.Facility, pay.FacilityCapped))
+		fmt.Fprintf(w, "non-facility\t%s\n", capped(pay.NonFacility, pay.NonFacilityCapped))
+		fmt.Fprintf(w, "%s\t%s\n", pay.Setting, na(pay.Amount))
+		return w.Flush()
+	},
+}
+
+// priceAnesthesiaCmd prices an anesthesia service from the loaded anesthesia
+// conversion factors
+var priceAnesthesiaCmd = &cobra.Command{
+	Use:   "anesthesia",
+	Short: "Price an anesthesia service in a locality from the anesthesia conversion factors in the db",
+	Long: `Price an anesthesia service in a locality from the anesthesia conversion
+factors in the db:
+
+  (base units + time units) x anesthesia cf x share
+
+using the release in effect on --date. The conversion factors have to have been
+loaded with load --anes. Time units are --minutes in 15 minute units, to one
+decimal place. The base units are either --base-units, or looked up for --hcpcs in
+--base-units-file, a csv of base units by code. The medical direction modifiers
+QK, QY and QX pay 50%, AA and QZ 100%. The locality is given as for price.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config()
+		if err != nil {
+			return err
+		}
+		flags := cmd.Flags()
+		minutes, _ := flags.GetInt("minutes")
+		modifiers, _ := flags.GetStringSlice("modifier")
+		date, _ := flags.GetString("date")
+		dos := time.Now().UTC()
+		if date != "" {
+			if dos, err = time.Parse(time.DateOnly, date); err != nil {
+				return err
+			}
+		}
+		hcpcs, _ := flags.GetString("hcpcs")
+		base, _ := flags.GetString("base-units")
+		baseUnits, err := cmsrvu.ParseDecimal(base)
+		if err != nil {
+			return err
+		}
+		var units cmsrvu.AnesthesiaBaseUnits
+		if file, _ := flags.GetString("base-units-file"); file != "" {
+			f, err := os.Open(file)
+			if err != nil {
+				return err
+			}
+			units, err = cmsrvu.ReadAnesthesiaBaseUnits(f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		}
+		if baseUnits.Valid == (hcpcs != "") || hcpcs != "" && units == nil {
+			return errors.New("use either --base-units, or --hcpcs and --base-units-file")
+		}
+
+		schema, _, _, err := tableNames(cmd, cfg)
+		if err != nil {
+			return err
+		}
+		anesTable, err := cfg.DB.TableName("anesthesia_cf")
+		if err != nil {
+			return err
+		}
+		db, err := connect(cfg)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		locality, err := flagLocality(cmd, cfg, db, schema, dos)
+		if err != nil {
+			return err
+		}
+		cfs, err := cmsrvu.QueryAnesthesiaCFs(cmd.Context(), db, schema, anesTable, pgtype.Date{Time: dos, Valid: true})
+		if err != nil {
+			return err
+		}
+
+		pricer := cmsrvu.Pricer{AnesthesiaCFs: cmsrvu.NewAnesthesiaCFIndex(cfs), BaseUnits: units}
+		var pay cmsrvu.AnesthesiaPayment
+		if hcpcs != "" {
+			pay, err = pricer.PriceAnesthesiaCode(hcpcs, minutes, locality, dos, modifiers...)
+		} else {
+			pay, err = pricer.PriceAnesthesia(baseUnits, minutes, locality, dos, modifiers...)
+		}
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+		if hcpcs != "" {
+			fmt.Fprintf(w, "hcpcs\t%s\n", hcpcs)
+		}
+		fmt.Fprintf(w, "locality\t%s %s\n", pay.Locality, pay.CF.LocalityName)
+		fmt.Fprintf(w, "release\t%s\n", pay.CF.EffectiveDate.Time.Format(time.DateOnly))
+		fmt.Fprintf(w, "base units\t%s\n", pay.BaseUnits)
+		fmt.Fprintf(w, "time units\t%s (%d minutes)\n", pay.TimeUnits, pay.Minutes)
+		fmt.Fprintf(w, "conversion factor\t%s\n", pay.CF.ConversionFactor)
+		fmt.Fprintf(w, "share\t%s %s\n", pay.Share, pay.Modifier)
+		fmt.Fprintf(w, "amount\t%s\n", pay.Amount)
+		return w.Flush()
+	},
+}
+
+// flagLocality returns the locality from --locality, or looks up --zip in the
+// zip_locality table
+func flagLocality(cmd *cobra.Command, cfg *cmsrvu.Config, db *sqlx.DB, schema string, dos time.Time) (cmsrvu.Locality, error) {
+	l, _ := cmd.Flags().GetString("locality")
+	zip, _ := cmd.Flags().GetString("zip")
+	switch {
+	case l != "" && zip != "":
+		return cmsrvu.Locality{}, errors.New("use either --locality or --zip")
+	case l != "":
+		return cmsrvu.ParseLocality(l)
+	case zip != "":
+		zipTable, err := cfg.DB.TableName("zip_locality")
+		if err != nil {
+			return cmsrvu.Locality{}, err
+		}
+		zls, err := cmsrvu.QueryZIPLocalities(cmd.Context(), db, schema, zipTable)
+		if err != nil {
+			return cmsrvu.Locality{}, err
+		}
+		zl, ok := cmsrvu.NewZIPLocalityIndex(zls).LocalityForZIP(zip, dos)
+		if !ok {
+			return cmsrvu.Locality{}, fmt.Errorf("no locality for zip %s on %s", zip, dos.Format(time.DateOnly))
+		}
+		return zl.Locality(), nil
+	}
+	return cmsrvu.Locality{}, errors.New("--locality or --zip is required")
+}
+
+func init() {
+	rootCmd.AddCommand(priceCmd)
+	priceCmd.Flags().String("hcpcs", "", "hcpcs code to price")
+	priceCmd.Flags().String("modifier", "", "modifier as in the rvu file, ie 26 or TC")
+	priceCmd.Flags().String("locality", "", "contractor and locality number, ie 01182-18")
+	priceCmd.Flags().String("zip", "", "service location zip code or zip+4, instead of --locality")
+	priceCmd.Flags().String("date", "", "date of service, ie 2024-08-01 (defaults to today)")
+	priceCmd.Flags().String("setting", "nonfacility", "facility or nonfacility")
+	priceCmd.Flags().Bool("oppscap", true, "cap codes the OPPS cap applies to at their OPPS payment amount")
+	priceCmd.MarkFlagRequired("hcpcs")
+
+	priceCmd.AddCommand(priceAnesthesiaCmd)
+	priceAnesthesiaCmd.Flags().String("hcpcs", "", "anesthesia code, its base units are read from --base-units-file")
+	priceAnesthesiaCmd.Flags().String("base-units", "", "base units of the service, instead of --hcpcs")
+	priceAnesthesiaCmd.Flags().String("base-units-file", "", "csv of anesthesia base units by code")
+	priceAnesthesiaCmd.Flags().Int("minutes", 0, "anesthesia time in minutes")
+	priceAnesthesiaCmd.Flags().StringSlice("modifier", nil, "modifiers, ie QK (can be repeated)")
+	priceAnesthesiaCmd.Flags().String("locality", "", "contractor and locality number, ie 01182-18")
+	priceAnesthesiaCmd.Flags().String("zip", "", "service location zip code or zip+4, instead of --locality")
+	priceAnesthesiaCmd.Flags().String("date", "", "date of service, ie 2024-08-01 (defaults to today)")
+}
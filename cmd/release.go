@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"log"
+	"time"
+
+	"github.com/exiledavatar/cmsrvu/cmsrvu"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/spf13/cobra"
+)
+
+// releaseCmd groups the commands that manage loaded releases
+var releaseCmd = &cobra.Command{
+	Use:   "release",
+	Short: "Manage releases that have been loaded into the db",
+}
+
+// releaseDeleteCmd removes a release so it can be reloaded or left out
+var releaseDeleteCmd = &cobra.Command{
+	Use:   "delete",
+	Short: "Delete a release from the db",
+	Long: `Delete a release from the db, along with its versions in the history table.
+
+Only the rows loaded from --source are deleted if it is set. The deletion is
+recorded in the load_log table with the number of rows deleted, even if there
+were none, and the release will be loaded again by the next load.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config()
+		if err != nil {
+			return err
+		}
+		flags := cmd.Flags()
+		date, _ := flags.GetString("effective-date")
+		effectiveDate, err := time.Parse(time.DateOnly, date)
+		if err != nil {
+			return err
+		}
+		source, _ := flags.GetString("source")
+		schema, table, loadLog, err := tableNames(cmd, cfg)
+		if err != nil {
+			return err
+		}
+
+		db, err := connect(cfg)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		loader := cmsrvu.Loader{DB: db, Schema: schema, Table: table, LoadLog: loadLog}
+		if gpci, _ := flags.GetBool("gpci"); gpci {
+			if loader.GPCITable, err = cfg.DB.TableName("gpci"); err != nil {
+				return err
+			}
+		}
+		if locco, _ := flags.GetBool("locco"); locco {
+			if loader.LocalityCountyTable, err = cfg.DB.TableName("locality_county"); err != nil {
+				return err
+			}
+		}
+		if anes, _ := flags.GetBool("anes"); anes {
+			if loader.AnesthesiaCFTable, err = cfg.DB.TableName("anesthesia_cf"); err != nil {
+				return err
+			}
+		}
+		if oppscap, _ := flags.GetBool("oppscap"); oppscap {
+			if loader.OPPSCapTable, err = cfg.DB.TableName("opps_cap"); err != nil {
+				return err
+			}
+		}
+		deleted, err := loader.DeleteRelease(cmd.Context(), pgtype.Date{Time: effectiveDate, Valid: true}, source)
+		if err != nil {
+			return err
+		}
+		log.Printf("%s: deleted %d rows", date, deleted)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(releaseCmd)
+	releaseCmd.AddCommand(releaseDeleteCmd)
+	releaseDeleteCmd.Flags().String("effective-date", "", "effective date of the release, ie 2024-07-01")
+	releaseDeleteCmd.Flags().String("source", "", "only delete rows loaded from this url")
+	releaseDeleteCmd.Flags().Bool("gpci", false, "also delete the release's GPCIs from the gpci table")
+	releaseDeleteCmd.Flags().Bool("locco", false, "also delete the release's counties from the locality_county table")
+	releaseDeleteCmd.Flags().Bool("anes", false, "also delete the release's anesthesia conversion factors from the anesthesia_cf table")
+	releaseDeleteCmd.Flags().Bool("oppscap", false, "also delete the release's OPPS payment caps from the opps_cap table")
+	releaseDeleteCmd.MarkFlagRequired("effective-date")
+}
@@ -7,10 +7,14 @@ import (
 	"log"
 	"os"
 
+	"github.com/exiledavatar/cmsrvu/cmsrvu"
+	"github.com/jmoiron/sqlx"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
+var cfgFile string
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "cmsrvu",
@@ -47,6 +51,50 @@ func Execute() {
 }
 
 func init() {
-	// rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.cmsrvu.yaml)")
+	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is cmsrvu.DefaultConfig)")
+	rootCmd.PersistentFlags().String("schema", "", "db schema (defaults to the config's DB.Schema, then cmsrvu)")
+	rootCmd.PersistentFlags().String("table", "", "base name of the db table (defaults to the config's DB.Table, then rvu)")
+
+}
+
+// config returns the config from --config, or the default config if it wasn't set
+func config() (*cmsrvu.Config, error) {
+	if cfgFile == "" {
+		cfg := cmsrvu.DefaultConfig
+		return &cfg, nil
+	}
+	return LoadAndParseConfig(cfgFile)
+}
+
+// connect opens the database described by cfg
+func connect(cfg *cmsrvu.Config) (*sqlx.DB, error) {
+	return cmsrvu.Open(cfg.DB)
+}
 
+// tableNames returns the schema, rvu table and load log table to use, from the
+// --schema and --table flags or cfg, with cfg.DB.TableNameTemplate applied to the tables
+func tableNames(cmd *cobra.Command, cfg *cmsrvu.Config) (schema, table, loadLog string, err error) {
+	schema, _ = cmd.Flags().GetString("schema")
+	if schema == "" {
+		schema = cfg.DB.Schema
+	}
+	if schema == "" {
+		schema = "cmsrvu"
+	}
+	if err := cmsrvu.ValidateIdentifier(schema); err != nil {
+		return "", "", "", err
+	}
+
+	base, _ := cmd.Flags().GetString("table")
+	if base == "" {
+		base = cfg.DB.Table
+	}
+	if base == "" {
+		base = "rvu"
+	}
+	if table, err = cfg.DB.TableName(base); err != nil {
+		return "", "", "", err
+	}
+	loadLog, err = cfg.DB.TableName("load_log")
+	return schema, table, loadLog, err
 }
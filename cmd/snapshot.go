@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/exiledavatar/cmsrvu/cmsrvu"
+	"github.com/spf13/cobra"
+)
+
+// snapshotCmd writes every configured release to a single parquet file, this is how
+// the snapshot embedded by the rvusnapshot package is generated
+var snapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Download the configured RVU releases and write them to a parquet snapshot",
+	Long: `Download the configured RVU releases and write them to a single zstd
+compressed parquet file, no db required.
+
+The rvusnapshot package embeds the snapshot, regenerate it with go generate
+./rvusnapshot.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config()
+		if err != nil {
+			return err
+		}
+		out, _ := cmd.Flags().GetString("out")
+
+		all := cmsrvu.RelativeValueUnits{}
+		for _, dc := range cfg.Data {
+			pattern := dc.FileRegex
+			if pattern == "" {
+				pattern = cfg.RVUFileRegex
+			}
+			rvus, err := cmsrvu.GetRVUs(dc.URL, "", pattern, dc.EffectiveDate)
+			if err != nil {
+				return err
+			}
+			log.Printf("%s %s: %d rvus", dc.EffectiveDate.Time.Format(time.DateOnly), dc.URL, len(rvus))
+			all = append(all, rvus...)
+		}
+
+		// write to a temporary file so a failure doesn't clobber the existing snapshot
+		f, err := os.CreateTemp(filepath.Dir(out), filepath.Base(out)+".*.tmp")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(f.Name())
+		if err := all.WriteParquet(f); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		if err := os.Chmod(f.Name(), 0o644); err != nil {
+			return err
+		}
+		return os.Rename(f.Name(), out)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(snapshotCmd)
+	snapshotCmd.Flags().String("out", "rvu.parquet", "snapshot file to write")
+}
@@ -0,0 +1,352 @@
+package cmsrvu
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jmoiron/sqlx"
+)
+
+// DefaultANESFileRegex matches the anesthesia conversion factor file in an rvu
+// archive, ie ANES2024.csv
+var DefaultANESFileRegex = `(?i)^anes.*\.csv$`
+
+// AnesthesiaCF is a line from CMS's ANES file - the anesthesia conversion factor of a
+// locality. Anesthesia services (status J) aren't priced from their rvus, see
+// Pricer.PriceAnesthesia.
+type AnesthesiaCF struct {
+	Source           string      `db:"_source"`
+	ExtractTime      time.Time   `db:"_extract_time"`
+	LastModified     time.Time   `db:"_last_modified"`
+	EffectiveDate    pgtype.Date `db:"_effective_date"`
+	MAC              string      `db:"mac"`
+	LocalityNumber   string      `db:"locality_number"` // two digits, ie 01
+	LocalityName     string      `db:"locality_name"`
+	ConversionFactor Decimal     `db:"conversion_factor"`
+}
+
+type AnesthesiaCFs []AnesthesiaCF
+
+var anesthesiaCFColumns = []string{
+	"_source",
+	"_extract_time",
+	"_last_modified",
+	"_effective_date",
+	"mac",
+	"locality_number",
+	"locality_name",
+	"conversion_factor",
+}
+
+var anesthesiaCFKey = []string{"_effective_date", "mac", "locality_number"}
+
+// Locality returns the locality of cf
+func (cf AnesthesiaCF) Locality() Locality { return Locality{cf.MAC, cf.LocalityNumber} }
+
+// anesContractor, anesLocality and anesCF match the ANES file's columns, they've been
+// named differently over the years
+func anesContractor(c string) bool {
+	return strings.Contains(c, "CONTRACTOR") || strings.Contains(c, "CARRIER") || strings.Contains(c, "MAC")
+}
+
+func anesLocality(c string) bool {
+	return strings.Contains(c, "LOCALITY") && !strings.Contains(c, "NAME")
+}
+
+func anesCF(c string) bool {
+	return strings.Contains(c, "CONVERSION") || c == "CF" || strings.HasSuffix(c, " CF")
+}
+
+// anesHeader matches the ANES file's header, the title above it mentions conversion
+// factors too but in the same cell as everything else
+func anesHeader(record []string) bool {
+	locality, cf := columnIndex(record, anesLocality), columnIndex(record, anesCF)
+	return locality >= 0 && cf >= 0 && locality != cf
+}
+
+// FetchAnesthesiaCFs downloads the release described by spec and parses its ANES file,
+// use WithFileRegex to override DefaultANESFileRegex
+func FetchAnesthesiaCFs(ctx context.Context, spec ReleaseSpec, opts ...Option) (AnesthesiaCFs, ReleaseMetadata, error) {
+	header, records, md, err := fetchCSV(ctx, spec, opts, DefaultANESFileRegex, anesHeader)
+	if err != nil {
+		return nil, md, err
+	}
+	cfs, err := AnesthesiaCFsFromRecords(header, records, md)
+	return cfs, md, err
+}
+
+// AnesthesiaCFsFromZip parses the ANES file from an archive that's already been
+// downloaded
+func AnesthesiaCFsFromZip(data []byte, md ReleaseMetadata) (AnesthesiaCFs, error) {
+	header, records, err := CSVFromZipHeader(data, DefaultANESFileRegex, anesHeader)
+	if err != nil {
+		return nil, err
+	}
+	return AnesthesiaCFsFromRecords(header, records, md)
+}
+
+// AnesthesiaCFsFromRecords converts the header and records of an ANES file (see
+// CSVFromZipHeader) to AnesthesiaCFs. Some years have a column for the national
+// conversion factor as well, the locality's is the last one. Rows without a contractor
+// number (footnotes, etc) are skipped.
+func AnesthesiaCFsFromRecords(header []string, records [][]string, md ReleaseMetadata) (AnesthesiaCFs, error) {
+	if !md.EffectiveDate.Valid {
+		return nil, errors.New("valid effectiveDate required")
+	}
+	cf := -1
+	for i := range header {
+		if anesCF(strings.ToUpper(cleanString(header[i]))) {
+			cf = i
+		}
+	}
+	cols := map[string]int{
+		"contractor":        columnIndex(header, anesContractor),
+		"locality number":   columnIndex(header, anesLocality),
+		"locality name":     columnIndex(header, containsAll("LOCALITY", "NAME")),
+		"conversion factor": cf,
+	}
+	for name, i := range cols {
+		if i < 0 {
+			return nil, fmt.Errorf("anes file has no %s column", name)
+		}
+	}
+
+	cfs := AnesthesiaCFs{}
+	for _, r := range records {
+		mac := cleanString(r[cols["contractor"]])
+		if !isDigits(mac) {
+			continue
+		}
+		a := AnesthesiaCF{
+			Source:         md.Source,
+			ExtractTime:    md.ExtractTime,
+			LastModified:   md.LastModified,
+			EffectiveDate:  md.EffectiveDate,
+			MAC:            mac,
+			LocalityNumber: localityNumber(r[cols["locality number"]]),
+			LocalityName:   strings.Trim(cleanString(r[cols["locality name"]]), "*"),
+		}
+		var err error
+		if a.ConversionFactor, err = ParseDecimal(r[cols["conversion factor"]]); err != nil {
+			return nil, err
+		}
+		cfs = append(cfs, a)
+	}
+	return cfs, nil
+}
+
+// CreateTable creates schema.table for AnesthesiaCFs in postgres or sqlite (schema is
+// ignored)
+func (cfs AnesthesiaCFs) CreateTable(ctx context.Context, db *sqlx.DB, schema, table string) (sql.Result, error) {
+	names, err := dbTableNames(db, schema, table)
+	if err != nil {
+		return nil, err
+	}
+	q := `
+	create table if not exists %[1]s (
+		_source text,
+		_extract_time %[2]s,
+		_last_modified %[2]s,
+		_effective_date date not null,
+		mac text not null,
+		locality_number text not null,
+		locality_name text,
+		conversion_factor %[3]s,
+		primary key (_effective_date, mac, locality_number)
+	)`
+	return createReferenceTable(ctx, db, names, q)
+}
+
+// Put writes cfs to schema.table, replacing the conversion factor of any locality
+// already loaded for the same release. It returns the number of rows written.
+func (cfs AnesthesiaCFs) Put(ctx context.Context, db *sqlx.DB, schema, table string) (int64, error) {
+	names, err := dbTableNames(db, schema, table)
+	if err != nil {
+		return 0, err
+	}
+	return putReferenceRows(ctx, db, names, anesthesiaCFColumns, anesthesiaCFKey, cfs)
+}
+
+// QueryAnesthesiaCFs reads the conversion factors in effect on dateOfService from
+// schema.table, or every release if dateOfService isn't valid, see QueryGPCIs
+func QueryAnesthesiaCFs(ctx context.Context, db *sqlx.DB, schema, table string, dateOfService pgtype.Date) (AnesthesiaCFs, error) {
+	names, err := dbTableNames(db, schema, table)
+	if err != nil {
+		return nil, err
+	}
+	q, args := queryInEffect(names, anesthesiaCFColumns, dateOfService)
+	cfs := AnesthesiaCFs{}
+	if err := db.SelectContext(ctx, &cfs, db.Rebind(q+"\norder by _effective_date, mac, locality_number"), args...); err != nil {
+		return nil, err
+	}
+	return cfs, nil
+}
+
+// AnesthesiaCFIndex looks up the anesthesia conversion factor of a locality on a date
+// of service from memory, see GPCIIndex
+type AnesthesiaCFIndex struct {
+	index localityIndex[AnesthesiaCF]
+}
+
+// NewAnesthesiaCFIndex indexes cfs, which can span any number of releases
+func NewAnesthesiaCFIndex(cfs AnesthesiaCFs) *AnesthesiaCFIndex {
+	return &AnesthesiaCFIndex{newLocalityIndex(cfs, func(cf AnesthesiaCF) (pgtype.Date, Locality, rvuKey) {
+		return cf.EffectiveDate, cf.Locality(), rvuKey{}
+	})}
+}
+
+// Lookup returns the conversion factor of locality in the release in effect on
+// dateOfService. ok is false if there's no such release or the locality isn't in it.
+func (x *AnesthesiaCFIndex) Lookup(locality Locality, dateOfService time.Time) (AnesthesiaCF, bool) {
+	return x.index.lookup(locality, rvuKey{}, dateOfService)
+}
+
+// BaseUnitSource looks up the anesthesia base units of a code. CMS doesn't publish
+// them in the rvu archives, see AnesthesiaBaseUnits.
+type BaseUnitSource interface {
+	BaseUnits(hcpcs string) (Decimal, bool)
+}
+
+// AnesthesiaBaseUnits maps anesthesia codes to their base units, see
+// ReadAnesthesiaBaseUnits
+type AnesthesiaBaseUnits map[string]Decimal
+
+// BaseUnits implements BaseUnitSource
+func (b AnesthesiaBaseUnits) BaseUnits(hcpcs string) (Decimal, bool) {
+	units, ok := b[hcpcs]
+	return units, ok
+}
+
+// baseUnitsHeader matches the header of a base unit file, it has a code and a base
+// unit column - the title above it can mention both in one cell
+func baseUnitsHeader(record []string) bool {
+	code, base := columnIndex(record, baseUnitsCode), columnIndex(record, containsAll("BASE"))
+	return code >= 0 && base >= 0 && code != base
+}
+
+func baseUnitsCode(c string) bool {
+	return strings.Contains(c, "CODE") || strings.Contains(c, "CPT") || strings.Contains(c, "HCPCS")
+}
+
+// ReadAnesthesiaBaseUnits reads a csv of anesthesia base units by code, ie CMS's
+// anesthesia base units by CPT code file saved as csv. The header is found as in the
+// rvu archives, and needs a code (or CPT or HCPCS) column and a base unit column.
+// Rows without a code or base units are skipped.
+func ReadAnesthesiaBaseUnits(r io.Reader) (AnesthesiaBaseUnits, error) {
+	csvReader, header, err := csvAfterHeader(r, baseUnitsHeader)
+	if err != nil {
+		return nil, err
+	}
+	records, err := csvReader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	code, base := columnIndex(header, baseUnitsCode), columnIndex(header, containsAll("BASE"))
+	units := AnesthesiaBaseUnits{}
+	for _, rec := range records {
+		hcpcs := strings.ToUpper(cleanString(rec[code]))
+		if hcpcs == "" {
+			continue
+		}
+		d, err := ParseDecimal(rec[base])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", hcpcs, err)
+		}
+		if d.Valid {
+			units[hcpcs] = d
+		}
+	}
+	return units, nil
+}
+
+// anesthesiaModifiers are the payment modifiers of anesthesia services and the share
+// of the allowance paid for each - medically directed services are split between the
+// anesthesiologist and the CRNA
+var anesthesiaModifiers = map[string]Decimal{
+	"AA": NewDecimal(1, 0),  // performed personally by an anesthesiologist
+	"QZ": NewDecimal(1, 0),  // CRNA without medical direction
+	"QK": NewDecimal(5, -1), // medical direction of 2-4 concurrent procedures
+	"QY": NewDecimal(5, -1), // medical direction of one CRNA
+	"QX": NewDecimal(5, -1), // CRNA with medical direction
+}
+
+// AnesthesiaPayment is an anesthesia fee schedule amount, see Pricer.PriceAnesthesia
+type AnesthesiaPayment struct {
+	Locality      Locality
+	DateOfService time.Time
+	Minutes       int
+	BaseUnits     Decimal
+	TimeUnits     Decimal // minutes / 15 to one decimal place
+	Modifier      string  // the payment modifier applied, "" if there wasn't one
+	Share         Decimal // of the allowance paid for Modifier
+	Amount        Decimal
+	CF            AnesthesiaCF
+}
+
+// PriceAnesthesia returns the payment for an anesthesia service of baseUnits and
+// minutes of anesthesia time in locality on dateOfService:
+//
+//	(base units + time units) × anesthesia conversion factor × share
+//
+// rounded to the cent. Time units are minutes in 15 minute units to one decimal
+// place. share is 50% for the medical direction modifiers (QK, QY and QX) and 100%
+// for AA, QZ or no payment modifier, other modifiers don't affect the payment.
+func (p Pricer) PriceAnesthesia(baseUnits Decimal, minutes int, locality Locality, dateOfService time.Time, modifiers ...string) (AnesthesiaPayment, error) {
+	pay := AnesthesiaPayment{
+		Locality:      locality,
+		DateOfService: dateOfService,
+		Minutes:       minutes,
+		BaseUnits:     baseUnits,
+		TimeUnits:     anesthesiaTimeUnits(minutes),
+		Share:         NewDecimal(1, 0),
+	}
+	if !baseUnits.Valid || minutes < 0 {
+		return pay, errors.New("base units and minutes >= 0 required")
+	}
+	for _, m := range modifiers {
+		m = strings.ToUpper(cleanString(m))
+		share, ok := anesthesiaModifiers[m]
+		if !ok {
+			continue
+		}
+		if pay.Modifier != "" && pay.Modifier != m {
+			return pay, fmt.Errorf("conflicting anesthesia modifiers %s and %s", pay.Modifier, m)
+		}
+		pay.Modifier, pay.Share = m, share
+	}
+	if p.AnesthesiaCFs == nil {
+		return pay, errors.New("no anesthesia conversion factors")
+	}
+	cf, ok := p.AnesthesiaCFs.Lookup(locality, dateOfService)
+	if !ok {
+		return pay, fmt.Errorf("no anesthesia conversion factor for locality %s on %s", locality, dateOfService.Format(time.DateOnly))
+	}
+	pay.CF = cf
+	pay.Amount = baseUnits.Add(pay.TimeUnits).Mul(cf.ConversionFactor).Mul(pay.Share).Round(2)
+	return pay, nil
+}
+
+// anesthesiaTimeUnits returns minutes in 15 minute units, rounded half up to one
+// decimal place
+func anesthesiaTimeUnits(minutes int) Decimal {
+	tenths := (int64(minutes)*20 + 15) / 30
+	return NewDecimal(tenths, -1)
+}
+
+// PriceAnesthesiaCode is PriceAnesthesia with the base units of hcpcs from BaseUnits
+func (p Pricer) PriceAnesthesiaCode(hcpcs string, minutes int, locality Locality, dateOfService time.Time, modifiers ...string) (AnesthesiaPayment, error) {
+	if p.BaseUnits == nil {
+		return AnesthesiaPayment{}, errors.New("no anesthesia base units")
+	}
+	units, ok := p.BaseUnits.BaseUnits(hcpcs)
+	if !ok {
+		return AnesthesiaPayment{}, fmt.Errorf("no anesthesia base units for %s", hcpcs)
+	}
+	return p.PriceAnesthesia(units, minutes, locality, dateOfService, modifiers...)
+}
@@ -0,0 +1,77 @@
+package cmsrvu
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAnesthesiaTimeUnits(t *testing.T) {
+	tests := []struct {
+		minutes int
+		want    string
+	}{
+		{0, "0.0"},
+		{1, "0.1"},  // 0.0667
+		{7, "0.5"},  // 0.4667
+		{8, "0.5"},  // 0.5333
+		{20, "1.3"}, // 1.3333
+		{60, "4.0"},
+		{67, "4.5"}, // 4.4667
+		{68, "4.5"}, // 4.5333
+	}
+	for _, tt := range tests {
+		if got := anesthesiaTimeUnits(tt.minutes).String(); got != tt.want {
+			t.Errorf("anesthesiaTimeUnits(%d) = %q, want %q", tt.minutes, got, tt.want)
+		}
+	}
+}
+
+func TestPriceAnesthesia(t *testing.T) {
+	locality := Locality{"10112", "00"}
+	pricer := Pricer{AnesthesiaCFs: NewAnesthesiaCFIndex(AnesthesiaCFs{
+		{EffectiveDate: july2024, MAC: "10112", LocalityNumber: "00", ConversionFactor: mustDecimal(t, "21.0549")},
+	})}
+	dos := time.Date(2024, 8, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		baseUnits string
+		minutes   int
+		modifiers []string
+		share     string
+		amount    string
+		err       bool
+	}{
+		// (5 + 4.5) × 21.0549 = 200.02155
+		{"no modifier", "5", 68, nil, "1", "200.02", false},
+		{"personally performed", "5", 68, []string{"AA"}, "1", "200.02", false},
+		{"crna without medical direction", "5", 68, []string{"qz"}, "1", "200.02", false},
+		// 200.02155 × 0.5 = 100.010775
+		{"medical direction", "5", 68, []string{"QK"}, "0.5", "100.01", false},
+		{"medically directed crna", "5", 68, []string{"QX"}, "0.5", "100.01", false},
+		// (4 + 1.3) × 21.0549 × 0.5 = 55.795485
+		{"one crna", "4", 20, []string{"QY"}, "0.5", "55.80", false},
+		{"other modifiers", "5", 68, []string{"P1", "QK", "QK"}, "0.5", "100.01", false},
+		{"no time", "7", 0, nil, "1", "147.38", false},
+		{"conflicting modifiers", "5", 68, []string{"QK", "AA"}, "", "", true},
+		{"no base units", "", 68, nil, "", "", true},
+		{"negative minutes", "5", -1, nil, "", "", true},
+	}
+	for _, tt := range tests {
+		pay, err := pricer.PriceAnesthesia(mustDecimal(t, tt.baseUnits), tt.minutes, locality, dos, tt.modifiers...)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: PriceAnesthesia error = %v, want error %t", tt.name, err, tt.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if pay.Share.String() != tt.share || pay.Amount.String() != tt.amount {
+			t.Errorf("%s: PriceAnesthesia = %q × %q, want %q × %q", tt.name, pay.Amount, pay.Share, tt.amount, tt.share)
+		}
+	}
+
+	if _, err := pricer.PriceAnesthesia(mustDecimal(t, "5"), 60, Locality{"00000", "99"}, dos); err == nil {
+		t.Error("PriceAnesthesia succeeded for a locality without a conversion factor")
+	}
+}
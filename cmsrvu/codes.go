@@ -0,0 +1,217 @@
+package cmsrvu
+
+import (
+	"database/sql"
+)
+
+// The code types below wrap the sql.Null* value of a code from the rvu file, so they
+// scan from and write to the db like the sql.Null* types. Each has a Label taken from
+// the matching ToX function and predicates for the behavior the code describes. A null
+// code's String and Label are empty.
+
+// StatusCode is the PFS status of a code, ie A (active) or B (bundled)
+type StatusCode struct{ sql.NullString }
+
+// PCTCIndicator is the professional component/technical component indicator
+type PCTCIndicator struct{ sql.NullInt64 }
+
+// GlobalPeriod is the global surgery period, ie 000, 010, 090 or XXX
+type GlobalPeriod struct{ sql.NullString }
+
+// MultipleProcedureIndicator says which multiple procedure payment reduction applies
+type MultipleProcedureIndicator struct{ sql.NullInt64 }
+
+// BilateralSurgeryIndicator says whether the bilateral adjustment applies
+type BilateralSurgeryIndicator struct{ sql.NullInt64 }
+
+// AssistantAtSurgeryIndicator says whether an assistant at surgery is paid
+type AssistantAtSurgeryIndicator struct{ sql.NullInt64 }
+
+// CoSurgeonsIndicator says whether co-surgeons are paid
+type CoSurgeonsIndicator struct{ sql.NullInt64 }
+
+// TeamSurgeryIndicator says whether a surgical team is paid
+type TeamSurgeryIndicator struct{ sql.NullInt64 }
+
+// SupervisionCode is the level of physician supervision a diagnostic procedure needs
+type SupervisionCode struct{ sql.NullString }
+
+// ImagingFamilyIndicator is the diagnostic imaging family of a code
+type ImagingFamilyIndicator struct{ sql.NullInt64 }
+
+func (c StatusCode) String() string { return fromSQLNullString(c.NullString) }
+func (c StatusCode) Label() string  { return ToStatus(c.NullString).String }
+
+func (c StatusCode) MarshalText() ([]byte, error) { return []byte(c.String()), nil }
+
+func (c *StatusCode) UnmarshalText(b []byte) error {
+	c.NullString = toSQLNullString(string(b))
+	return nil
+}
+
+// IsPayable reports whether the code can be paid under the PFS - A, R and T codes are
+// paid from their rvus (R with restricted coverage, T only if no other service is
+// billed that day) and C codes are priced by the contractor
+func (c StatusCode) IsPayable() bool {
+	switch c.String() {
+	case "A", "C", "R", "T":
+		return true
+	}
+	return false
+}
+
+// IsBundled reports whether payment is bundled into other services (B and P)
+func (c StatusCode) IsBundled() bool {
+	switch c.String() {
+	case "B", "P":
+		return true
+	}
+	return false
+}
+
+func (c PCTCIndicator) String() string { return fromSQLNullInt64(c.NullInt64) }
+func (c PCTCIndicator) Label() string  { return ToPCTC(c.NullInt64).String }
+
+func (c PCTCIndicator) MarshalText() ([]byte, error) { return []byte(c.String()), nil }
+
+func (c *PCTCIndicator) UnmarshalText(b []byte) error {
+	c.NullInt64 = toSQLNullInt64(string(b))
+	return nil
+}
+
+// HasComponents reports whether the service splits into professional (modifier 26) and
+// technical (modifier TC) components
+func (c PCTCIndicator) HasComponents() bool { return c.Valid && c.Int64 == 1 }
+
+func (c GlobalPeriod) String() string { return fromSQLNullString(c.NullString) }
+func (c GlobalPeriod) Label() string  { return ToGlobalSurgery(c.NullString).String }
+
+func (c GlobalPeriod) MarshalText() ([]byte, error) { return []byte(c.String()), nil }
+
+func (c *GlobalPeriod) UnmarshalText(b []byte) error {
+	c.NullString = toSQLNullString(string(b))
+	return nil
+}
+
+// GlobalDays returns the number of postoperative days in the global period, ok is
+// false for periods that aren't a number of days (MMM, XXX, YYY and ZZZ)
+func (c GlobalPeriod) GlobalDays() (days int, ok bool) {
+	switch c.String() {
+	case "0", "000":
+		return 0, true
+	case "10", "010":
+		return 10, true
+	case "90", "090":
+		return 90, true
+	}
+	return 0, false
+}
+
+func (c MultipleProcedureIndicator) String() string { return fromSQLNullInt64(c.NullInt64) }
+func (c MultipleProcedureIndicator) Label() string  { return ToMultipleProcedure(c.NullInt64).String }
+
+func (c MultipleProcedureIndicator) MarshalText() ([]byte, error) { return []byte(c.String()), nil }
+
+func (c *MultipleProcedureIndicator) UnmarshalText(b []byte) error {
+	c.NullInt64 = toSQLNullInt64(string(b))
+	return nil
+}
+
+// ReductionApplies reports whether a multiple procedure payment reduction applies
+func (c MultipleProcedureIndicator) ReductionApplies() bool {
+	return c.Valid && c.Int64 >= 1 && c.Int64 <= 7
+}
+
+func (c BilateralSurgeryIndicator) String() string { return fromSQLNullInt64(c.NullInt64) }
+func (c BilateralSurgeryIndicator) Label() string  { return ToBilateralSurgery(c.NullInt64).String }
+
+func (c BilateralSurgeryIndicator) MarshalText() ([]byte, error) { return []byte(c.String()), nil }
+
+func (c *BilateralSurgeryIndicator) UnmarshalText(b []byte) error {
+	c.NullInt64 = toSQLNullInt64(string(b))
+	return nil
+}
+
+// AdjustmentApplies reports whether bilateral procedures are paid at 150%
+func (c BilateralSurgeryIndicator) AdjustmentApplies() bool { return c.Valid && c.Int64 == 1 }
+
+func (c AssistantAtSurgeryIndicator) String() string { return fromSQLNullInt64(c.NullInt64) }
+func (c AssistantAtSurgeryIndicator) Label() string {
+	return ToAssistantAtSurgery(c.NullInt64).String
+}
+
+func (c AssistantAtSurgeryIndicator) MarshalText() ([]byte, error) { return []byte(c.String()), nil }
+
+func (c *AssistantAtSurgeryIndicator) UnmarshalText(b []byte) error {
+	c.NullInt64 = toSQLNullInt64(string(b))
+	return nil
+}
+
+// Permitted reports whether an assistant at surgery is paid without restriction
+func (c AssistantAtSurgeryIndicator) Permitted() bool { return c.Valid && c.Int64 == 2 }
+
+// RequiresDocumentation reports whether an assistant is only paid with documentation
+// of medical necessity
+func (c AssistantAtSurgeryIndicator) RequiresDocumentation() bool { return c.Valid && c.Int64 == 0 }
+
+func (c CoSurgeonsIndicator) String() string { return fromSQLNullInt64(c.NullInt64) }
+func (c CoSurgeonsIndicator) Label() string  { return ToCosurgeons(c.NullInt64).String }
+
+func (c CoSurgeonsIndicator) MarshalText() ([]byte, error) { return []byte(c.String()), nil }
+
+func (c *CoSurgeonsIndicator) UnmarshalText(b []byte) error {
+	c.NullInt64 = toSQLNullInt64(string(b))
+	return nil
+}
+
+// Permitted reports whether co-surgeons are paid without documentation
+func (c CoSurgeonsIndicator) Permitted() bool { return c.Valid && c.Int64 == 2 }
+
+// RequiresDocumentation reports whether co-surgeons are only paid with documentation
+// of medical necessity
+func (c CoSurgeonsIndicator) RequiresDocumentation() bool { return c.Valid && c.Int64 == 1 }
+
+func (c TeamSurgeryIndicator) String() string { return fromSQLNullInt64(c.NullInt64) }
+func (c TeamSurgeryIndicator) Label() string  { return ToTeamSurgery(c.NullInt64).String }
+
+func (c TeamSurgeryIndicator) MarshalText() ([]byte, error) { return []byte(c.String()), nil }
+
+func (c *TeamSurgeryIndicator) UnmarshalText(b []byte) error {
+	c.NullInt64 = toSQLNullInt64(string(b))
+	return nil
+}
+
+// Permitted reports whether a surgical team is paid without documentation
+func (c TeamSurgeryIndicator) Permitted() bool { return c.Valid && c.Int64 == 2 }
+
+// RequiresDocumentation reports whether a surgical team is only paid with
+// documentation of medical necessity
+func (c TeamSurgeryIndicator) RequiresDocumentation() bool { return c.Valid && c.Int64 == 1 }
+
+func (c SupervisionCode) String() string { return fromSQLNullString(c.NullString) }
+func (c SupervisionCode) Label() string {
+	return ToPhysicianSupervisionOfDiagnosticProcedures(c.NullString).String
+}
+
+func (c SupervisionCode) MarshalText() ([]byte, error) { return []byte(c.String()), nil }
+
+func (c *SupervisionCode) UnmarshalText(b []byte) error {
+	c.NullString = toSQLNullString(string(b))
+	return nil
+}
+
+func (c ImagingFamilyIndicator) String() string { return fromSQLNullInt64(c.NullInt64) }
+func (c ImagingFamilyIndicator) Label() string  { return ToDiagnosticImagingFamily(c.NullInt64).String }
+
+func (c ImagingFamilyIndicator) MarshalText() ([]byte, error) { return []byte(c.String()), nil }
+
+func (c *ImagingFamilyIndicator) UnmarshalText(b []byte) error {
+	c.NullInt64 = toSQLNullInt64(string(b))
+	return nil
+}
+
+// ReductionApplies reports whether the code is in an imaging family subject to the
+// multiple procedure reduction
+func (c ImagingFamilyIndicator) ReductionApplies() bool {
+	return c.Valid && c.Int64 != 99 && c.Int64 != 0
+}
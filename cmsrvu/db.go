@@ -0,0 +1,50 @@
+package cmsrvu
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+)
+
+// Drivers supported by Open, the sqlite driver is pure go so it doesn't need cgo
+const (
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+)
+
+func init() {
+	// sqlx only knows the cgo sqlite driver as sqlite3
+	sqlx.BindDriver(DriverSQLite, sqlx.QUESTION)
+}
+
+// Open connects to the database described by cfg. Driver defaults to postgres,
+// for sqlite ConnectionString is the data source name, ie "file:cmsrvu.db".
+func Open(cfg DBConfig) (*sqlx.DB, error) {
+	switch cfg.Driver {
+	case DriverPostgres, "":
+		return sqlx.Connect(DriverPostgres, cfg.ConnectionString)
+	case DriverSQLite:
+		// store times in a format sqlite's date and time functions understand
+		dsn := cfg.ConnectionString
+		if !strings.Contains(dsn, "_time_format=") {
+			sep := "?"
+			if strings.Contains(dsn, "?") {
+				sep = "&"
+			}
+			dsn += sep + "_time_format=sqlite"
+		}
+		db, err := sqlx.Connect(DriverSQLite, dsn)
+		if err != nil {
+			return nil, err
+		}
+		// sqlite only allows one writer at a time, and each connection to an
+		// in-memory database gets its own database
+		db.SetMaxOpenConns(1)
+		return db, nil
+	default:
+		return nil, fmt.Errorf("unsupported driver: %s", cfg.Driver)
+	}
+}
@@ -0,0 +1,265 @@
+package cmsrvu
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"gopkg.in/yaml.v3"
+)
+
+// Decimal is an exact decimal number, Int × 10^Exp, for payment math that has to tie
+// to CMS's published amounts to the cent. It's null when it isn't Valid, and any
+// arithmetic involving a null is null. It scans from and writes to numeric and text
+// columns, and marshals to json and yaml numbers, without going through a float.
+type Decimal struct{ pgtype.Numeric }
+
+// NewDecimal returns i × 10^exp, ie NewDecimal(332875, -4) is 33.2875
+func NewDecimal(i int64, exp int32) Decimal {
+	return Decimal{pgtype.Numeric{Int: big.NewInt(i), Exp: exp, Valid: true}}
+}
+
+var decimalRegex = regexp.MustCompile(`[^\d.-]+`)
+
+// ParseDecimal parses a value from a CMS file, it's cleaned the same way as the float
+// fields (except that the sign is kept) and is null if empty
+func ParseDecimal(s string) (Decimal, error) {
+	cleaned := decimalRegex.ReplaceAllString(cleanString(s), "")
+	if cleaned == "" {
+		return Decimal{}, nil
+	}
+	d := Decimal{}
+	if err := d.Numeric.Scan(cleaned); err != nil {
+		return Decimal{}, fmt.Errorf("invalid decimal %q: %w", s, err)
+	}
+	return d, nil
+}
+
+// DecimalFromFloat converts f using the shortest decimal that round trips, which is
+// exactly the value that was parsed for anything with up to 15 significant digits -
+// CMS publishes rvus, gpcis and conversion factors with at most 4 decimal places
+func DecimalFromFloat(f sql.NullFloat64) Decimal {
+	if !f.Valid {
+		return Decimal{}
+	}
+	d, _ := ParseDecimal(strconv.FormatFloat(f.Float64, 'f', -1, 64))
+	return d
+}
+
+// Add returns d + o
+func (d Decimal) Add(o Decimal) Decimal {
+	if !d.Valid || !o.Valid {
+		return Decimal{}
+	}
+	exp := min(d.Exp, o.Exp)
+	sum := new(big.Int).Add(d.scaled(exp), o.scaled(exp))
+	return Decimal{pgtype.Numeric{Int: sum, Exp: exp, Valid: true}}
+}
+
+// Mul returns d × o
+func (d Decimal) Mul(o Decimal) Decimal {
+	if !d.Valid || !o.Valid {
+		return Decimal{}
+	}
+	return Decimal{pgtype.Numeric{Int: new(big.Int).Mul(d.Int, o.Int), Exp: d.Exp + o.Exp, Valid: true}}
+}
+
+// Neg returns -d
+func (d Decimal) Neg() Decimal {
+	if !d.Valid {
+		return d
+	}
+	return Decimal{pgtype.Numeric{Int: new(big.Int).Neg(d.Int), Exp: d.Exp, Valid: true}}
+}
+
+// Round rounds d to places decimal places, halves away from zero. This is how CMS
+// rounds - ie payment amounts are rounded to the cent once, after multiplying by the
+// conversion factor, never before.
+func (d Decimal) Round(places int32) Decimal {
+	if !d.Valid || d.Exp >= -places {
+		return d
+	}
+	divisor := pow10(-places - d.Exp)
+	q, r := new(big.Int).QuoRem(new(big.Int).Abs(d.Int), divisor, new(big.Int))
+	if r.Lsh(r, 1).Cmp(divisor) >= 0 {
+		q.Add(q, big.NewInt(1))
+	}
+	if d.Int.Sign() < 0 {
+		q.Neg(q)
+	}
+	return Decimal{pgtype.Numeric{Int: q, Exp: -places, Valid: true}}
+}
+
+// Min returns the lesser of d and o
+func (d Decimal) Min(o Decimal) Decimal {
+	if d.Cmp(o) <= 0 {
+		return d
+	}
+	return o
+}
+
+// Cmp compares d and o like big.Int.Cmp, nulls sort first
+func (d Decimal) Cmp(o Decimal) int {
+	switch {
+	case !d.Valid && !o.Valid:
+		return 0
+	case !d.Valid:
+		return -1
+	case !o.Valid:
+		return 1
+	}
+	exp := min(d.Exp, o.Exp)
+	return d.scaled(exp).Cmp(o.scaled(exp))
+}
+
+// IsZero reports whether d is a valid zero
+func (d Decimal) IsZero() bool {
+	return d.Valid && d.Int.Sign() == 0
+}
+
+// Float64 returns the nearest float to d, 0 if it's null
+func (d Decimal) Float64() float64 {
+	if !d.Valid {
+		return 0
+	}
+	f, _ := new(big.Rat).SetFrac(d.scaled(min(d.Exp, 0)), pow10(max(-d.Exp, 0))).Float64()
+	return f
+}
+
+// NullFloat64 converts d to a float, SetIDHash hashes floats so ids don't change
+func (d Decimal) NullFloat64() sql.NullFloat64 {
+	return sql.NullFloat64{Float64: d.Float64(), Valid: d.Valid}
+}
+
+// String formats d without an exponent, ie 33.2875, and is empty if d is null
+func (d Decimal) String() string {
+	if !d.Valid {
+		return ""
+	}
+	if d.Exp >= 0 {
+		return d.scaled(0).String()
+	}
+	digits := new(big.Int).Abs(d.Int).String()
+	places := int(-d.Exp)
+	if len(digits) <= places {
+		digits = strings.Repeat("0", places-len(digits)+1) + digits
+	}
+	sign := ""
+	if d.Int.Sign() < 0 {
+		sign = "-"
+	}
+	return sign + digits[:len(digits)-places] + "." + digits[len(digits)-places:]
+}
+
+// Scan reads numeric columns as text from postgres and text columns from sqlite. Sqlite
+// tables created before the decimal columns were text store them as reals, those are
+// read as floats or integers - see DecimalFromFloat.
+func (d *Decimal) Scan(src any) error {
+	switch src := src.(type) {
+	case nil:
+		*d = Decimal{}
+		return nil
+	case []byte:
+		return d.scanText(string(src))
+	case string:
+		return d.scanText(src)
+	case float64:
+		*d = DecimalFromFloat(sql.NullFloat64{Float64: src, Valid: true})
+		return nil
+	case int64:
+		*d = NewDecimal(src, 0)
+		return nil
+	}
+	return fmt.Errorf("cannot scan %T into Decimal", src)
+}
+
+// Value writes d as text so postgres and sqlite store it exactly
+func (d Decimal) Value() (driver.Value, error) {
+	if !d.Valid {
+		return nil, nil
+	}
+	return d.String(), nil
+}
+
+// MarshalJSON writes d as a json number with the same digits as String, or null
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	if !d.Valid {
+		return []byte("null"), nil
+	}
+	return []byte(d.String()), nil
+}
+
+// UnmarshalJSON reads a json number, or a string holding one, exactly
+func (d *Decimal) UnmarshalJSON(b []byte) error {
+	s := string(b)
+	if s == "null" {
+		*d = Decimal{}
+		return nil
+	}
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	}
+	return d.scanText(s)
+}
+
+// MarshalYAML writes d as a plain yaml scalar with the same digits as String, or null
+func (d Decimal) MarshalYAML() (any, error) {
+	if !d.Valid {
+		return nil, nil
+	}
+	return &yaml.Node{Kind: yaml.ScalarNode, Value: d.String()}, nil
+}
+
+// UnmarshalYAML reads a yaml scalar exactly, yaml nulls never get here and are zero
+func (d *Decimal) UnmarshalYAML(value *yaml.Node) error {
+	return d.scanText(value.Value)
+}
+
+// scanText parses s exactly, as written by String, strconv or encoding/json, ie 33.2875
+// or 1e-05
+func (d *Decimal) scanText(s string) error {
+	mantissa, exponent, found := strings.Cut(strings.ToLower(s), "e")
+	n := pgtype.Numeric{}
+	if err := n.Scan(mantissa); err != nil {
+		return fmt.Errorf("invalid decimal %q: %w", s, err)
+	}
+	if n.NaN || n.InfinityModifier != pgtype.Finite {
+		return fmt.Errorf("invalid decimal %q", s)
+	}
+	if found {
+		e, err := strconv.ParseInt(exponent, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid decimal %q: %w", s, err)
+		}
+		n.Exp += int32(e)
+	}
+	*d = Decimal{n}
+	return nil
+}
+
+// scaled returns d's digits at exponent exp, which must be <= d.Exp
+func (d Decimal) scaled(exp int32) *big.Int {
+	return new(big.Int).Mul(d.Int, pow10(d.Exp-exp))
+}
+
+func pow10(n int32) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+// NationalPayment returns the unadjusted (national) facility and non-facility payment
+// amounts, total rvus × conversion factor rounded to the cent, the same as the
+// _national_payment view. An amount is null if the setting's NA indicator is set.
+func (r RelativeValueUnit) NationalPayment() (facility, nonFacility Decimal) {
+	if !r.FacilityNAIndicator {
+		facility = r.TotalFacilityRVU.Mul(r.ConversionFactor).Round(2)
+	}
+	if !r.NonFacilityNAIndicator {
+		nonFacility = r.TotalNonFacilityRVU.Mul(r.ConversionFactor).Round(2)
+	}
+	return facility, nonFacility
+}
@@ -0,0 +1,182 @@
+package cmsrvu
+
+import (
+	"database/sql"
+	"encoding/json"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func mustDecimal(t *testing.T, s string) Decimal {
+	t.Helper()
+	d, err := ParseDecimal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestParseDecimal(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"33.2875", "33.2875"},
+		{" 0.00 ", "0.00"},
+		{"$1,234.50", "1234.50"},
+		{"-0.07", "-0.07"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := mustDecimal(t, tt.in).String(); got != tt.want {
+			t.Errorf("ParseDecimal(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+	if _, err := ParseDecimal("1.2.3"); err == nil {
+		t.Error("ParseDecimal(1.2.3) succeeded")
+	}
+}
+
+func TestDecimalRound(t *testing.T) {
+	tests := []struct {
+		in     string
+		places int32
+		want   string
+	}{
+		{"1.005", 2, "1.01"},
+		{"1.0049", 2, "1.00"},
+		{"1.015", 2, "1.02"},
+		{"2.5", 0, "3"},
+		{"-2.5", 0, "-3"},
+		{"-1.005", 2, "-1.01"},
+		{"-1.0049", 2, "-1.00"},
+		{"0.004", 2, "0.00"},
+		{"-0.005", 2, "-0.01"},
+		{"1.5", 2, "1.5"}, // already fewer places
+		{"", 2, ""},
+	}
+	for _, tt := range tests {
+		if got := mustDecimal(t, tt.in).Round(tt.places).String(); got != tt.want {
+			t.Errorf("%q.Round(%d) = %q, want %q", tt.in, tt.places, got, tt.want)
+		}
+	}
+}
+
+func TestDecimalAddMul(t *testing.T) {
+	tests := []struct{ a, b, sum, product string }{
+		{"1.5", "2.25", "3.75", "3.375"},
+		{"0.85", "33.2875", "34.1375", "28.294375"},
+		{"10", "0.001", "10.001", "0.01"}, // 10 parses as 1 × 10^1
+		{"-1.2", "0.2", "-1.0", "-0.24"},
+		{"1", "", "", ""},
+	}
+	for _, tt := range tests {
+		a, b := mustDecimal(t, tt.a), mustDecimal(t, tt.b)
+		if got := a.Add(b).String(); got != tt.sum {
+			t.Errorf("%s + %s = %q, want %q", tt.a, tt.b, got, tt.sum)
+		}
+		if got := a.Mul(b).String(); got != tt.product {
+			t.Errorf("%s × %s = %q, want %q", tt.a, tt.b, got, tt.product)
+		}
+	}
+}
+
+func TestDecimalCmp(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.50", "1.5", 0},
+		{"1.49", "1.5", -1},
+		{"-1", "-2", 1},
+		{"", "0", -1},
+		{"", "", 0},
+	}
+	for _, tt := range tests {
+		if got := mustDecimal(t, tt.a).Cmp(mustDecimal(t, tt.b)); got != tt.want {
+			t.Errorf("Cmp(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestDecimalFromFloat(t *testing.T) {
+	for _, s := range []string{"33.2875", "0.01", "2.36", "1.046", "20.0"} {
+		d := mustDecimal(t, s)
+		if got := DecimalFromFloat(d.NullFloat64()); got.Cmp(d) != 0 {
+			t.Errorf("DecimalFromFloat(%s) = %s", s, got)
+		}
+	}
+	if got := DecimalFromFloat(sql.NullFloat64{}); got.Valid {
+		t.Errorf("DecimalFromFloat(null) = %s, want null", got)
+	}
+}
+
+func TestDecimalJSON(t *testing.T) {
+	tests := []struct{ in, want, out string }{
+		{"33.2875", "33.2875", "33.2875"},
+		{"2.060", "2.060", "2.060"},
+		{`"0.02"`, "0.02", "0.02"},
+		{"1e-05", "0.00001", "0.00001"}, // as encoding/json writes small floats
+		{"null", "", "null"},
+	}
+	for _, tt := range tests {
+		var d Decimal
+		if err := json.Unmarshal([]byte(tt.in), &d); err != nil {
+			t.Errorf("json.Unmarshal(%s): %v", tt.in, err)
+			continue
+		}
+		if d.String() != tt.want {
+			t.Errorf("json.Unmarshal(%s) = %q, want %q", tt.in, d, tt.want)
+		}
+		if b, err := json.Marshal(d); err != nil || string(b) != tt.out {
+			t.Errorf("json.Marshal(%q) = %s, %v, want %s", d, b, err, tt.out)
+		}
+	}
+	if err := json.Unmarshal([]byte(`"NaN"`), new(Decimal)); err == nil {
+		t.Error("json.Unmarshal(NaN) succeeded")
+	}
+}
+
+func TestDecimalYAML(t *testing.T) {
+	in := struct{ A, B, C Decimal }{A: mustDecimal(t, "33.2875"), B: mustDecimal(t, "0.00")}
+	b, err := yaml.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "a: 33.2875\nb: 0.00\nc: null\n"; string(b) != want {
+		t.Errorf("yaml.Marshal = %q, want %q", b, want)
+	}
+	var out struct{ A, B, C Decimal }
+	if err := yaml.Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.A.String() != "33.2875" || out.B.String() != "0.00" || out.C.Valid {
+		t.Errorf("yaml.Unmarshal = %q, %q, %q", out.A, out.B, out.C)
+	}
+}
+
+func TestRVUFromRecordDecimals(t *testing.T) {
+	record := []string{
+		"70450", "TC", "Ct head/brain w/o dye", "A", "", "0.00", "2.06", "", "2.06", "NA",
+		"0.01", "2.07", "2.07", "1", "XXX", "0.00", "0.00", "0.00", "4", "0", "0", "0", "0",
+		"", "33.2875", "01", "0", "88", "3.18", "3.18", "0.02",
+	}
+	r, err := RVUFromRecord(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		got  Decimal
+		want string
+	}{
+		{"WRVU", r.WRVU, "0.00"},
+		{"NonFacilityPERVU", r.NonFacilityPERVU, "2.06"},
+		{"FacilityPERVU", r.FacilityPERVU, "2.06"},
+		{"ConversionFactor", r.ConversionFactor, "33.2875"},
+		{"MalpracticeUsedForOppsPaymentAmount", r.MalpracticeUsedForOppsPaymentAmount, "0.02"},
+	}
+	for _, tt := range tests {
+		if tt.got.String() != tt.want {
+			t.Errorf("%s = %q, want %q", tt.name, tt.got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package cmsrvu
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// FileSink exports each release to a csv file in Dir named <Name>_<effective date>.csv,
+// ie rvu_2024-07-01.csv. The header is the db column names. Files are written to a
+// temporary file and renamed on Commit, so a failed release never leaves a partial
+// export behind.
+type FileSink struct {
+	Dir  string
+	Name string // defaults to rvu
+
+	file *os.File
+	w    *csv.Writer
+	path string
+}
+
+func (f *FileSink) CreateSchema(ctx context.Context) error {
+	return os.MkdirAll(f.Dir, 0o755)
+}
+
+func (f *FileSink) BeginRelease(ctx context.Context, release DataConfig) error {
+	name := f.Name
+	if name == "" {
+		name = "rvu"
+	}
+	f.path = filepath.Join(f.Dir, fmt.Sprintf("%s_%s.csv", name, release.EffectiveDate.Time.Format(time.DateOnly)))
+	file, err := os.CreateTemp(f.Dir, filepath.Base(f.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	// CreateTemp only gives the owner access
+	if err := file.Chmod(0o644); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	f.file = file
+	f.w = csv.NewWriter(file)
+	return f.w.Write(rvuColumns)
+}
+
+func (f *FileSink) WriteBatch(ctx context.Context, rvus RelativeValueUnits) error {
+	for _, rvu := range rvus {
+		if err := f.w.Write(rvu.csvRecord()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *FileSink) Commit(ctx context.Context) error {
+	f.w.Flush()
+	if err := errors.Join(f.w.Error(), f.file.Close()); err != nil {
+		return err
+	}
+	err := os.Rename(f.file.Name(), f.path)
+	f.file = nil
+	return err
+}
+
+func (f *FileSink) Abort(ctx context.Context) error {
+	if f.file == nil {
+		return nil
+	}
+	f.file.Close()
+	err := os.Remove(f.file.Name())
+	f.file = nil
+	return err
+}
+
+// csvRecord formats r in the same column order as rvuColumns, nulls are empty strings
+func (r RelativeValueUnit) csvRecord() []string {
+	return []string{
+		r.IDHash,
+		r.NaturalKey,
+		strconv.FormatInt(r.Variant, 10),
+		r.ContentHash,
+		r.HashVersion,
+		r.Source,
+		r.ExtractTime.Format(time.RFC3339Nano),
+		r.LastModified.Format(time.RFC3339Nano),
+		r.EffectiveDate.Time.Format(time.DateOnly),
+		r.HCPCS,
+		fromSQLNullString(r.ModifierCode),
+		fromSQLNullString(r.Modifier),
+		fromSQLNullString(r.Description),
+		fromSQLNullString(r.StatusCode.NullString),
+		fromSQLNullString(r.Status),
+		r.WRVU.String(),
+		r.NonFacilityPERVU.String(),
+		strconv.FormatBool(r.NonFacilityNAIndicator),
+		r.FacilityPERVU.String(),
+		strconv.FormatBool(r.FacilityNAIndicator),
+		r.MalpracticeRVU.String(),
+		r.TotalNonFacilityRVU.String(),
+		r.TotalFacilityRVU.String(),
+		fromSQLNullInt64(r.PCTCIndicator.NullInt64),
+		fromSQLNullString(r.PCTC),
+		fromSQLNullString(r.GlobalSurgeryCode.NullString),
+		fromSQLNullString(r.GlobalSurgery),
+		fromSQLNullFloat64(r.PreoperativePercentage),
+		fromSQLNullFloat64(r.IntraoperativePercentage),
+		fromSQLNullFloat64(r.PostoperativePercentage),
+		fromSQLNullInt64(r.MultipleProcedureCode.NullInt64),
+		fromSQLNullString(r.MultipleProcedure),
+		fromSQLNullInt64(r.BilateralSurgeryCode.NullInt64),
+		fromSQLNullString(r.BilateralSurgery),
+		fromSQLNullInt64(r.AssistantAtSurgeryCode.NullInt64),
+		fromSQLNullString(r.AssistantAtSurgery),
+		fromSQLNullInt64(r.CoSurgeonsCode.NullInt64),
+		fromSQLNullString(r.CoSurgeons),
+		fromSQLNullInt64(r.TeamSurgeryCode.NullInt64),
+		fromSQLNullString(r.TeamSurgery),
+		fromSQLNullString(r.EndoscopicBaseCode),
+		r.ConversionFactor.String(),
+		fromSQLNullString(r.PhysicianSupervisionOfDiagnosticProceduresCode.NullString),
+		fromSQLNullString(r.PhysicianSupervisionOfDiagnosticProcedures),
+		fromSQLNullInt64(r.CalculationFlag),
+		fromSQLNullInt64(r.DiagnosticImagingFamilyIndicator.NullInt64),
+		fromSQLNullString(r.DiagnosticImagingFamily),
+		r.NonFacilityPEUsedForOppsPaymentAmount.String(),
+		r.FacilityPEUsedForOppsPaymentAmount.String(),
+		r.MalpracticeUsedForOppsPaymentAmount.String(),
+	}
+}
+
+// legacyCSVColumns is the header of files written before the natural key and content
+// hash columns were added
+var legacyCSVColumns = slices.Concat(rvuColumns[:1], rvuColumns[5:])
+
+// ReadCSV reads a csv file written by FileSink
+func ReadCSV(r io.Reader) (RelativeValueUnits, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+	columns := rvuColumns
+	legacy := len(header) == len(legacyCSVColumns)
+	if legacy {
+		columns = legacyCSVColumns
+	}
+	if len(header) != len(columns) {
+		return nil, fmt.Errorf("unexpected number of csv columns %d, expected %d", len(header), len(columns))
+	}
+	for i, c := range columns {
+		if header[i] != c {
+			return nil, fmt.Errorf("unexpected csv column %d: %q, expected %q", i+1, header[i], c)
+		}
+	}
+
+	rvus := RelativeValueUnits{}
+	for {
+		record, err := cr.Read()
+		if err == io.EOF && legacy {
+			return rvus, rvus.setMissingKeys()
+		}
+		if err == io.EOF {
+			return rvus, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if legacy {
+			record = slices.Insert(record, 1, "", "", "", "")
+		}
+		rvu, err := rvuFromCSVRecord(record)
+		if err != nil {
+			line, _ := cr.FieldPos(0)
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		rvus = append(rvus, rvu)
+	}
+}
+
+// rvuFromCSVRecord is the inverse of csvRecord
+func rvuFromCSVRecord(in []string) (RelativeValueUnit, error) {
+	errs := []error{}
+	parseTime := func(s string) time.Time {
+		t, err := time.Parse(time.RFC3339Nano, s)
+		errs = append(errs, err)
+		return t
+	}
+	parseInt := func(s string) int64 {
+		if s == "" {
+			return 0
+		}
+		i, err := strconv.ParseInt(s, 10, 64)
+		errs = append(errs, err)
+		return i
+	}
+	parseDecimal := func(s string) Decimal {
+		d, err := ParseDecimal(s)
+		errs = append(errs, err)
+		return d
+	}
+	parseBool := func(s string) bool {
+		b, err := strconv.ParseBool(s)
+		errs = append(errs, err)
+		return b
+	}
+	effectiveDate, err := time.Parse(time.DateOnly, in[8])
+	errs = append(errs, err)
+
+	r := RelativeValueUnit{
+		IDHash:                   in[0],
+		NaturalKey:               in[1],
+		Variant:                  parseInt(in[2]),
+		ContentHash:              in[3],
+		HashVersion:              in[4],
+		Source:                   in[5],
+		ExtractTime:              parseTime(in[6]),
+		LastModified:             parseTime(in[7]),
+		EffectiveDate:            pgtype.Date{Time: effectiveDate, Valid: true},
+		HCPCS:                    in[9],
+		ModifierCode:             toSQLNullString(in[10]),
+		Modifier:                 toSQLNullString(in[11]),
+		Description:              toSQLNullString(in[12]),
+		StatusCode:               StatusCode{toSQLNullString(in[13])},
+		Status:                   toSQLNullString(in[14]),
+		WRVU:                     parseDecimal(in[15]),
+		NonFacilityPERVU:         parseDecimal(in[16]),
+		NonFacilityNAIndicator:   parseBool(in[17]),
+		FacilityPERVU:            parseDecimal(in[18]),
+		FacilityNAIndicator:      parseBool(in[19]),
+		MalpracticeRVU:           parseDecimal(in[20]),
+		TotalNonFacilityRVU:      parseDecimal(in[21]),
+		TotalFacilityRVU:         parseDecimal(in[22]),
+		PCTCIndicator:            PCTCIndicator{toSQLNullInt64(in[23])},
+		PCTC:                     toSQLNullString(in[24]),
+		GlobalSurgeryCode:        GlobalPeriod{toSQLNullString(in[25])},
+		GlobalSurgery:            toSQLNullString(in[26]),
+		PreoperativePercentage:   toSQLNullFloat64(in[27]),
+		IntraoperativePercentage: toSQLNullFloat64(in[28]),
+		PostoperativePercentage:  toSQLNullFloat64(in[29]),
+		MultipleProcedureCode:    MultipleProcedureIndicator{toSQLNullInt64(in[30])},
+		MultipleProcedure:        toSQLNullString(in[31]),
+		BilateralSurgeryCode:     BilateralSurgeryIndicator{toSQLNullInt64(in[32])},
+		BilateralSurgery:         toSQLNullString(in[33]),
+		AssistantAtSurgeryCode:   AssistantAtSurgeryIndicator{toSQLNullInt64(in[34])},
+		AssistantAtSurgery:       toSQLNullString(in[35]),
+		CoSurgeonsCode:           CoSurgeonsIndicator{toSQLNullInt64(in[36])},
+		CoSurgeons:               toSQLNullString(in[37]),
+		TeamSurgeryCode:          TeamSurgeryIndicator{toSQLNullInt64(in[38])},
+		TeamSurgery:              toSQLNullString(in[39]),
+		EndoscopicBaseCode:       toSQLNullString(in[40]),
+		ConversionFactor:         parseDecimal(in[41]),
+		PhysicianSupervisionOfDiagnosticProceduresCode: SupervisionCode{toSQLNullString(in[42])},
+		PhysicianSupervisionOfDiagnosticProcedures:     toSQLNullString(in[43]),
+		CalculationFlag:                       toSQLNullInt64(in[44]),
+		DiagnosticImagingFamilyIndicator:      ImagingFamilyIndicator{toSQLNullInt64(in[45])},
+		DiagnosticImagingFamily:               toSQLNullString(in[46]),
+		NonFacilityPEUsedForOppsPaymentAmount: parseDecimal(in[47]),
+		FacilityPEUsedForOppsPaymentAmount:    parseDecimal(in[48]),
+		MalpracticeUsedForOppsPaymentAmount:   parseDecimal(in[49]),
+	}
+	return r, errors.Join(errs...)
+}
+
+func fromSQLNullString(s sql.NullString) string {
+	if !s.Valid {
+		return ""
+	}
+	return s.String
+}
+
+func fromSQLNullFloat64(f sql.NullFloat64) string {
+	if !f.Valid {
+		return ""
+	}
+	return strconv.FormatFloat(f.Float64, 'f', -1, 64)
+}
+
+func fromSQLNullInt64(i sql.NullInt64) string {
+	if !i.Valid {
+		return ""
+	}
+	return strconv.FormatInt(i.Int64, 10)
+}
@@ -0,0 +1,340 @@
+package cmsrvu
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// ReleaseSpec identifies a release to fetch, see DataConfig.Spec
+type ReleaseSpec struct {
+	URL           string
+	EffectiveDate pgtype.Date
+	FileRegex     string // the file to parse from the archive, defaults to DefaultRVUFileRegex
+}
+
+// Spec returns the ReleaseSpec for dc
+func (dc DataConfig) Spec() ReleaseSpec {
+	return ReleaseSpec{URL: dc.URL, EffectiveDate: dc.EffectiveDate, FileRegex: dc.FileRegex}
+}
+
+// ReleaseMetadata describes where and when a release was fetched from
+type ReleaseMetadata struct {
+	Source        string // the release's url
+	EffectiveDate pgtype.Date
+	LastModified  time.Time // from the response's Last-Modified header
+	ExtractTime   time.Time // from the response's Date header
+	Checksum      string    // sha256 of the archive, see Checksum
+	Cached        bool      // the archive was read from the cache rather than downloaded
+}
+
+// Map returns m in the form returned by GetRecords
+func (m ReleaseMetadata) Map() map[string]any {
+	return map[string]any{
+		"source":        m.Source,
+		"last-modified": m.LastModified,
+		"extract-time":  m.ExtractTime,
+		"checksum":      m.Checksum,
+	}
+}
+
+// ParserMode determines what happens when a record can't be parsed
+type ParserMode int
+
+const (
+	// ParseStrict stops at the first record that can't be parsed (the default)
+	ParseStrict ParserMode = iota
+	// ParseLenient logs and skips records that can't be parsed
+	ParseLenient
+)
+
+// Option configures FetchRelease, FetchRecords and IterRelease
+type Option func(*fetchOptions)
+
+type fetchOptions struct {
+	client    *http.Client
+	cacheDir  string
+	fileRegex string
+	mode      ParserMode
+	logger    *slog.Logger
+}
+
+// WithHTTPClient sets the client used to download archives, the default is
+// http.DefaultClient
+func WithHTTPClient(client *http.Client) Option {
+	return func(o *fetchOptions) { o.client = client }
+}
+
+// WithCacheDir keeps downloaded archives in dir and reuses them rather than
+// downloading them again. The response headers are kept alongside each archive so
+// the metadata is the same as the original download. Archives are named after a hash
+// of their url, see cacheName.
+func WithCacheDir(dir string) Option {
+	return func(o *fetchOptions) { o.cacheDir = dir }
+}
+
+// WithFileRegex overrides ReleaseSpec.FileRegex
+func WithFileRegex(pattern string) Option {
+	return func(o *fetchOptions) { o.fileRegex = pattern }
+}
+
+// WithParserMode sets how records that can't be parsed are handled
+func WithParserMode(mode ParserMode) Option {
+	return func(o *fetchOptions) { o.mode = mode }
+}
+
+// WithLogger sets the logger for downloads and skipped records, nothing is logged by
+// default
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *fetchOptions) { o.logger = logger }
+}
+
+func newFetchOptions(spec ReleaseSpec, opts []Option) fetchOptions {
+	o := fetchOptions{
+		client:    http.DefaultClient,
+		fileRegex: spec.FileRegex,
+		logger:    slog.New(discardHandler{}),
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.fileRegex == "" {
+		o.fileRegex = DefaultRVUFileRegex
+	}
+	return o
+}
+
+// FetchRelease downloads and parses the release described by spec
+func FetchRelease(ctx context.Context, spec ReleaseSpec, opts ...Option) (RelativeValueUnits, ReleaseMetadata, error) {
+	rvus := RelativeValueUnits{}
+	var md ReleaseMetadata
+	for rvu, err := range iterRelease(ctx, spec, opts, &md) {
+		if err != nil {
+			return nil, md, err
+		}
+		rvus = append(rvus, rvu)
+	}
+	return rvus, md, nil
+}
+
+// FetchRecords downloads the release described by spec and returns the raw csv records
+// of the matching file, after the header
+func FetchRecords(ctx context.Context, spec ReleaseSpec, opts ...Option) ([][]string, ReleaseMetadata, error) {
+	o := newFetchOptions(spec, opts)
+	data, md, err := fetchArchive(ctx, spec, o)
+	if err != nil {
+		return nil, md, err
+	}
+	records, err := CSVFromZip(data, o.fileRegex)
+	return records, md, err
+}
+
+// IterRelease is FetchRelease, yielding rvus as they are parsed rather than returning
+// them all at once, see IterRVUs
+func IterRelease(ctx context.Context, spec ReleaseSpec, opts ...Option) iter.Seq2[RelativeValueUnit, error] {
+	return iterRelease(ctx, spec, opts, nil)
+}
+
+// iterRelease does the work of FetchRelease and IterRelease, md is filled in once the
+// archive has been fetched if it isn't nil
+func iterRelease(ctx context.Context, spec ReleaseSpec, opts []Option, md *ReleaseMetadata) iter.Seq2[RelativeValueUnit, error] {
+	return func(yield func(RelativeValueUnit, error) bool) {
+		if !spec.EffectiveDate.Valid {
+			yield(RelativeValueUnit{}, errors.New("valid effectiveDate required"))
+			return
+		}
+		o := newFetchOptions(spec, opts)
+		data, meta, err := fetchArchive(ctx, spec, o)
+		if md != nil {
+			*md = meta
+		}
+		if err != nil {
+			yield(RelativeValueUnit{}, err)
+			return
+		}
+
+		parseRelease(ctx, data, meta, o, yield)
+	}
+}
+
+// parseRelease yields the rvus parsed from data, the archive of the release described
+// by md, for iterRelease and IterRVUsFromZip
+func parseRelease(ctx context.Context, data []byte, md ReleaseMetadata, o fetchOptions, yield func(RelativeValueUnit, error) bool) {
+	csvReader, _, rc, err := openCSVFromZip(data, o.fileRegex, rvuHeader)
+	if err != nil {
+		yield(RelativeValueUnit{}, err)
+		return
+	}
+	defer rc.Close()
+
+	variants := variantCounter{}
+	for {
+		if err := ctx.Err(); err != nil {
+			yield(RelativeValueUnit{}, err)
+			return
+		}
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			yield(RelativeValueUnit{}, err)
+			return
+		}
+		rvu, ok, err := rvuFromRecord(record, md.Source, md.ExtractTime, md.LastModified, md.EffectiveDate, variants)
+		if err != nil && o.mode == ParseLenient {
+			line, _ := csvReader.FieldPos(0)
+			o.logger.Warn("skipping record", "source", md.Source, "line", line, "error", err)
+			continue
+		}
+		if err != nil {
+			yield(rvu, err)
+			return
+		}
+		if ok && !yield(rvu, nil) {
+			return
+		}
+	}
+}
+
+// fetchArchive returns the archive for spec, from the cache if there is one
+func fetchArchive(ctx context.Context, spec ReleaseSpec, o fetchOptions) ([]byte, ReleaseMetadata, error) {
+	md := ReleaseMetadata{Source: spec.URL, EffectiveDate: spec.EffectiveDate}
+
+	cacheFile := ""
+	if o.cacheDir != "" {
+		name, err := cacheName(spec.URL)
+		if err != nil {
+			return nil, md, err
+		}
+		cacheFile = filepath.Join(o.cacheDir, name)
+	}
+
+	data, headers, err := readCache(cacheFile)
+	switch {
+	case err == nil:
+		md.Cached = true
+		o.logger.Debug("using cached archive", "source", spec.URL, "file", cacheFile)
+	case errors.Is(err, os.ErrNotExist):
+		o.logger.Info("downloading", "source", spec.URL)
+		data, headers, err = DownloadContext(ctx, o.client, spec.URL)
+		if err != nil {
+			return nil, md, err
+		}
+		if err := writeCache(cacheFile, data, headers); err != nil {
+			return nil, md, err
+		}
+	default:
+		return nil, md, err
+	}
+
+	if err := md.setFromResponse(data, headers); err != nil {
+		return nil, md, err
+	}
+	return data, md, nil
+}
+
+// setFromResponse sets the times and checksum of md from a downloaded archive and its
+// response headers
+func (md *ReleaseMetadata) setFromResponse(data []byte, headers http.Header) error {
+	lastModified, err := time.Parse(time.RFC1123, headers.Get("Last-Modified"))
+	if err != nil {
+		return err
+	}
+	extractTime, err := time.Parse(time.RFC1123, headers.Get("Date"))
+	if err != nil {
+		return err
+	}
+	md.LastModified = lastModified.UTC()
+	md.ExtractTime = extractTime.UTC()
+	md.Checksum = Checksum(data)
+	return nil
+}
+
+// cacheName returns the name an archive is cached under. CMS's urls often end in the
+// same path element, ie rvu24b-updated-03/18/2024.zip and rvu24c-updated-09/09/2024.zip
+// both end in 2024.zip, so the name is a hash of the whole url followed by the last
+// element for readability.
+func cacheName(srcUrl string) (string, error) {
+	u, err := url.Parse(srcUrl)
+	if err != nil {
+		return "", err
+	}
+	return Checksum([]byte(srcUrl))[:16] + "-" + path.Base(u.Path), nil
+}
+
+// DownloadContext is Download with a context and client
+func DownloadContext(ctx context.Context, client *http.Client, srcUrl string) ([]byte, http.Header, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srcUrl, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("%s: %s", srcUrl, resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	return data, resp.Header, err
+}
+
+// readCache reads an archive and its headers, it returns an error wrapping
+// os.ErrNotExist if file is empty or hasn't been cached
+func readCache(file string) ([]byte, http.Header, error) {
+	if file == "" {
+		return nil, nil, os.ErrNotExist
+	}
+	hb, err := os.ReadFile(file + ".headers.json")
+	if err != nil {
+		return nil, nil, err
+	}
+	headers := http.Header{}
+	if err := json.Unmarshal(hb, &headers); err != nil {
+		return nil, nil, err
+	}
+	data, err := os.ReadFile(file)
+	return data, headers, err
+}
+
+// writeCache saves an archive and its headers, the headers are written last so a
+// partially written cache is never read
+func writeCache(file string, data []byte, headers http.Header) error {
+	if file == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(file, data, 0o644); err != nil {
+		return err
+	}
+	hb, err := json.Marshal(headers)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file+".headers.json", hb, 0o644)
+}
+
+// discardHandler is a slog.Handler that drops everything, slog.DiscardHandler needs go 1.24
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (d discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return d }
+func (d discardHandler) WithGroup(string) slog.Handler           { return d }
@@ -2,12 +2,17 @@ package cmsrvu
 
 import (
 	"archive/zip"
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/sha256"
 	"encoding/csv"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"regexp"
+	"strings"
 	"time"
 )
 
@@ -22,100 +27,155 @@ type Records struct {
 	Meta map[string]any
 }
 
-// GetRecords is a high level function to get records from a zip file, and fall back
-// to importing directly from source url if there is any error getting it from the file.
+// GetRecords is a high level function to get records from a zip file. The meta data
+// keys are those of ReleaseMetadata.Map. cacheFile is ignored, FetchRecords with
+// WithCacheDir replaces it and returns typed meta data.
 func GetRecords(srcUrl, cacheFile, pattern string) ([][]string, map[string]any, error) {
-
-	zippedData, headers, err := Download(srcUrl)
+	records, md, err := FetchRecords(context.Background(), ReleaseSpec{URL: srcUrl, FileRegex: pattern})
 	if err != nil {
 		return nil, nil, err
 	}
-	// fmt.Println("Downloaded data: ", srcUrl, " - ", len(zippedData))
+	return records, md.Map(), nil
+}
+
+// RecordsFromZip does the work of GetRecords once the archive has been downloaded. The
+// returned meta data includes the source url, last-modified and extract-time from the
+// response headers and a sha256 checksum of the archive.
+func RecordsFromZip(zippedData []byte, headers http.Header, srcUrl, pattern string) ([][]string, map[string]any, error) {
 	meta := map[string]any{}
-	lastModified, err := time.Parse(time.RFC1123, headers["Last-Modified"][0])
+	lastModified, err := time.Parse(time.RFC1123, headers.Get("Last-Modified"))
 	if err != nil {
 		return nil, nil, err
 	}
-	extractTime, err := time.Parse(time.RFC1123, headers["Date"][0])
+	extractTime, err := time.Parse(time.RFC1123, headers.Get("Date"))
 	if err != nil {
 		return nil, nil, err
 	}
 	meta["last-modified"] = lastModified.UTC()
 	meta["extract-time"] = extractTime.UTC()
 	meta["source"] = srcUrl
+	meta["checksum"] = Checksum(zippedData)
 
 	records, err := CSVFromZip(zippedData, pattern)
 	// fmt.Println("Got records from data: ", len(records))
 	return records, meta, err
 }
 
+// Checksum returns the hex encoded sha256 sum of data, it's used to tell whether
+// an archive has changed since it was last loaded
+func Checksum(data []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(data))
+}
+
 // Download is a simple wrapper that reads the response to a byte slice and returns it
-// along with the response headers
+// along with the response headers, see DownloadContext
 func Download(srcUrl string) ([]byte, http.Header, error) {
-	resp, err := http.Get(srcUrl)
-	if err != nil {
-		return nil, nil, err
-	}
-	defer resp.Body.Close()
-
-	data, err := io.ReadAll(resp.Body)
-	return data, resp.Header, err
+	return DownloadContext(context.Background(), http.DefaultClient, srcUrl)
 }
 
 // CSVFromZip returns parsed csv records from from zip data. It extracts the first
-// file in an archive that matches pattern (using standard regexp matching).
+// file in an archive that matches pattern (using standard regexp matching) and returns
+// the records after the rvu file's header, see CSVFromZipHeader.
 func CSVFromZip(data []byte, pattern string) ([][]string, error) {
+	_, records, err := CSVFromZipHeader(data, pattern, rvuHeader)
+	return records, err
+}
 
-	// open a zip file handler
-	zipReader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
-	if err != nil {
-		return nil, err
+// HeaderFunc reports whether a record is the header of a CMS csv file. The files
+// start with a varying number of title and copyright rows, so the header is found by
+// looking at each row in turn.
+type HeaderFunc func(record []string) bool
+
+// HeaderStartsWith matches rows whose first cell starts with prefix, ignoring case
+func HeaderStartsWith(prefix string) HeaderFunc {
+	prefix = strings.ToUpper(prefix)
+	return func(record []string) bool {
+		return len(record) > 0 && strings.HasPrefix(strings.ToUpper(cleanString(record[0])), prefix)
 	}
+}
 
-	// get first file in zip that matches pattern
-	pat, err := regexp.Compile(pattern)
+// rvuHeader matches the header of the PPRRVU file
+var rvuHeader = HeaderStartsWith("HCPCS")
+
+// maxHeaderRows is how far into a file the header is looked for
+const maxHeaderRows = 50
+
+// CSVFromZipHeader is CSVFromZip for any of the csv files in a CMS archive, it returns
+// the header matched by isHeader and the records after it
+func CSVFromZipHeader(data []byte, pattern string, isHeader HeaderFunc) ([]string, [][]string, error) {
+	csvReader, header, rc, err := openCSVFromZip(data, pattern, isHeader)
 	if err != nil {
-		return nil, err
-	}
-	var zipFile *zip.File
-	for _, f := range zipReader.File {
-		if pat.MatchString(f.Name) {
-			zipFile = f
-			fmt.Println(f.Name)
-			break
-		}
+		return nil, nil, err
 	}
+	defer rc.Close()
 
-	// parse csv records
-	rc, err := zipFile.Open()
+	records, err := csvReader.ReadAll()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	defer rc.Close()
+	// fmt.Println(len(records))
+	// fmt.Printf("%#v+\n", records)
+	// fmt.Println(len(records[0]))
+
+	// fmt.Printf("%v\n", records[0][571169])
+
+	// if len(records) == 1 && len(records[0]) > 1 {
+	// 	// records = records[0]
+
+	// }
 
-	// read to a []byte
-	bd, err := io.ReadAll(rc)
+	// for _, v := range records {
+	// 	// fmt.Println(i, "\n")
+	// 	for j, vj := range v {
+	// 		fmt.Println("\t", j, "\t", vj, "wtf")
+	// 		if j > 1000 {
+	// 			break
+	// 		}
+	// 	}
+	// }
+	// fmt.Printf("%+v\n", records[1][0:5])
+
+	return header, records, nil
+}
+
+// openCSVFromZip opens the first file in the archive that matches pattern and returns
+// a csv reader positioned after the header matched by isHeader, along with the header.
+// The reader expects every record to have as many fields as the header, the closer
+// closes the file.
+func openCSVFromZip(data []byte, pattern string, isHeader HeaderFunc) (*csv.Reader, []string, io.Closer, error) {
+	zipFile, rc, err := openFromZip(data, pattern)
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, err
 	}
+	csvReader, header, err := csvAfterHeader(rc, isHeader)
+	if err != nil {
+		rc.Close()
+		return nil, nil, nil, fmt.Errorf("%s: %w", zipFile.Name, err)
+	}
+	return csvReader, header, rc, nil
+}
+
+// csvAfterHeader returns a csv reader positioned after the header matched by isHeader,
+// along with the header. The reader expects every record to have as many fields as
+// the header.
+func csvAfterHeader(r io.Reader, isHeader HeaderFunc) (*csv.Reader, []string, error) {
 	// scrub all the funky characters
 	// cbd := cleanBytes(bd)
 	// cbr := bytes.NewReader(cbd)
 
 	// someone at CMS decided to change how they save their CSV's - hopefully this addresses the issue...
 	// but consider moving to the txt files as they supposedly guarantee consistent formatting
-	bd = bytes.ReplaceAll(bd, []byte("\r"), []byte("\n"))
 
 	// fmt.Printf("%#v\n", string(bd[0:1000]))
-	cbr := bytes.NewReader(bd)
 	// convert []byte to an io.Reader
 	// fmt.Println(zipFile.FileHeader)
 	// fmt.Println(zipFile.FileInfo())
-	csvReader := csv.NewReader(cbr)
-	// csvReader.FieldsPerRecord = 31
+	csvReader := csv.NewReader(crToLF{r})
+	// title rows don't always have as many fields as the header
+	csvReader.FieldsPerRecord = -1
 
-	// this loop is to burn through the junk rows and the header
-	for i := range 20 {
+	// burn through the junk rows up to and including the header
+	for range maxHeaderRows {
 		// fmt.Println(i)
 		// record, err := csvReader.Read()
 		// if err != nil {
@@ -132,50 +192,96 @@ func CSVFromZip(data []byte, pattern string) ([][]string, error) {
 		// 	break
 		// }
 
-		switch record, _ := csvReader.Read(); {
-		case len(record) == 0:
-			// fmt.Println("this is zero length")
-			//do nothing
-		case record[0] == "HCPCS":
-			// fmt.Println(i, " found the header row")
+		record, err := csvReader.Read()
+		if err == io.EOF {
 			break
-		case i > 20:
-			return nil, fmt.Errorf("cannot find header in first 20 rows, check file")
-		default:
-			// fmt.Println("me? ", i, "\t", record[0])
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			// junk rows aren't always valid csv
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if isHeader(record) {
+			csvReader.FieldsPerRecord = len(record)
+			return csvReader, record, nil
 		}
 	}
+	return nil, nil, fmt.Errorf("cannot find header in first %d rows, check file", maxHeaderRows)
+}
 
-	records, err := csvReader.ReadAll()
+// errNoFileInArchive is returned when no file in an archive matches the pattern
+var errNoFileInArchive = errors.New("no file in archive matches")
 
+// openFromZip opens the first file in the archive that matches pattern
+func openFromZip(data []byte, pattern string) (*zip.File, io.ReadCloser, error) {
+
+	// open a zip file handler
+	zipReader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
 	if err != nil {
-		fmt.Println("Error reading records: ", err)
+		return nil, nil, err
+	}
 
-		return nil, err
+	// get first file in zip that matches pattern
+	pat, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, nil, err
+	}
+	var zipFile *zip.File
+	for _, f := range zipReader.File {
+		if pat.MatchString(f.Name) {
+			zipFile = f
+			break
+		}
+	}
+	if zipFile == nil {
+		return nil, nil, fmt.Errorf("%w %s", errNoFileInArchive, pattern)
 	}
-	// fmt.Println(len(records))
-	// fmt.Printf("%#v+\n", records)
-	// fmt.Println(len(records[0]))
 
-	// fmt.Printf("%v\n", records[0][571169])
+	rc, err := zipFile.Open()
+	if err != nil {
+		return nil, nil, err
+	}
+	return zipFile, rc, nil
+}
 
-	// if len(records) == 1 && len(records[0]) > 1 {
-	// 	// records = records[0]
+// LinesFromZip returns the lines of the first file in the archive that matches
+// pattern, for CMS's fixed width files. Blank lines are dropped.
+func LinesFromZip(data []byte, pattern string) ([]string, error) {
+	zipFile, rc, err := openFromZip(data, pattern)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
 
-	// }
+	lines := []string{}
+	scanner := bufio.NewScanner(crToLF{rc})
+	for scanner.Scan() {
+		if line := strings.TrimRight(scanner.Text(), " \t"); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", zipFile.Name, err)
+	}
+	return lines, nil
+}
 
-	// for _, v := range records {
-	// 	// fmt.Println(i, "\n")
-	// 	for j, vj := range v {
-	// 		fmt.Println("\t", j, "\t", vj, "wtf")
-	// 		if j > 1000 {
-	// 			break
-	// 		}
-	// 	}
-	// }
-	// fmt.Printf("%+v\n", records[1][0:5])
+// crToLF replaces carriage returns with line feeds as they're read
+type crToLF struct {
+	r io.Reader
+}
 
-	return records, err
+func (c crToLF) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	for i, b := range p[:n] {
+		if b == '\r' {
+			p[i] = '\n'
+		}
+	}
+	return n, err
 }
 
 // func cleanBytes(data []byte) []byte {
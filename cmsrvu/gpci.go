@@ -0,0 +1,195 @@
+package cmsrvu
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jmoiron/sqlx"
+)
+
+// DefaultGPCIFileRegex matches the GPCI file in an rvu archive, ie GPCI2024.csv
+var DefaultGPCIFileRegex = `(?i)^gpci.*\.csv$`
+
+// GPCI is a line from CMS's GPCI file - the work, practice expense and malpractice
+// geographic practice cost indices of a locality. Localities are identified by the
+// Medicare administrative contractor and locality number, locality numbers on their
+// own aren't unique.
+type GPCI struct {
+	Source         string      `db:"_source"`
+	ExtractTime    time.Time   `db:"_extract_time"`
+	LastModified   time.Time   `db:"_last_modified"`
+	EffectiveDate  pgtype.Date `db:"_effective_date"`
+	MAC            string      `db:"mac"`
+	State          string      `db:"state"`
+	LocalityNumber string      `db:"locality_number"` // two digits, ie 01
+	LocalityName   string      `db:"locality_name"`
+	WorkGPCI       Decimal     `db:"work_gpci"` // with the 1.0 floor where the file has both
+	PEGPCI         Decimal     `db:"pe_gpci"`
+	MPGPCI         Decimal     `db:"mp_gpci"`
+}
+
+type GPCIs []GPCI
+
+var gpciColumns = []string{
+	"_source",
+	"_extract_time",
+	"_last_modified",
+	"_effective_date",
+	"mac",
+	"state",
+	"locality_number",
+	"locality_name",
+	"work_gpci",
+	"pe_gpci",
+	"mp_gpci",
+}
+
+var gpciKey = []string{"_effective_date", "mac", "locality_number"}
+
+// Locality returns the locality of g
+func (g GPCI) Locality() Locality { return Locality{g.MAC, g.LocalityNumber} }
+
+// gpciHeader matches the GPCI file's header, the title row mentions GPCIs once but the
+// header has a column for each of them
+func gpciHeader(record []string) bool {
+	n := 0
+	for _, cell := range record {
+		if strings.Contains(strings.ToUpper(cell), "GPCI") {
+			n++
+		}
+	}
+	return n >= 3
+}
+
+// FetchGPCIs downloads the release described by spec and parses its GPCI file, use
+// WithFileRegex to override DefaultGPCIFileRegex
+func FetchGPCIs(ctx context.Context, spec ReleaseSpec, opts ...Option) (GPCIs, ReleaseMetadata, error) {
+	header, records, md, err := fetchCSV(ctx, spec, opts, DefaultGPCIFileRegex, gpciHeader)
+	if err != nil {
+		return nil, md, err
+	}
+	gpcis, err := GPCIsFromRecords(header, records, md)
+	return gpcis, md, err
+}
+
+// GPCIsFromZip parses the GPCI file from an archive that's already been downloaded
+func GPCIsFromZip(data []byte, md ReleaseMetadata) (GPCIs, error) {
+	header, records, err := CSVFromZipHeader(data, DefaultGPCIFileRegex, gpciHeader)
+	if err != nil {
+		return nil, err
+	}
+	return GPCIsFromRecords(header, records, md)
+}
+
+// GPCIsFromRecords converts the header and records of a GPCI file (see
+// CSVFromZipHeader) to GPCIs. Columns are found by name since they've moved between
+// years, and rows without a contractor number (footnotes, etc) are skipped.
+func GPCIsFromRecords(header []string, records [][]string, md ReleaseMetadata) (GPCIs, error) {
+	if !md.EffectiveDate.Valid {
+		return nil, errors.New("valid effectiveDate required")
+	}
+	work := columnIndex(header, func(c string) bool {
+		return (strings.Contains(c, "PW GPCI") || strings.Contains(c, "WORK GPCI")) && !strings.Contains(c, "WITHOUT")
+	})
+	if work < 0 {
+		work = columnIndex(header, func(c string) bool {
+			return strings.Contains(c, "PW GPCI") || strings.Contains(c, "WORK GPCI")
+		})
+	}
+	cols := map[string]int{
+		"mac": columnIndex(header, func(c string) bool {
+			return strings.Contains(c, "CONTRACTOR") || strings.Contains(c, "MAC")
+		}),
+		"state": columnIndex(header, func(c string) bool { return c == "STATE" }),
+		"locality number": columnIndex(header, func(c string) bool {
+			return strings.Contains(c, "LOCALITY") && !strings.Contains(c, "NAME")
+		}),
+		"locality name": columnIndex(header, containsAll("LOCALITY", "NAME")),
+		"work gpci":     work,
+		"pe gpci":       columnIndex(header, containsAll("PE GPCI")),
+		"mp gpci":       columnIndex(header, containsAll("MP GPCI")),
+	}
+	for name, i := range cols {
+		if i < 0 {
+			return nil, fmt.Errorf("gpci file has no %s column", name)
+		}
+	}
+
+	gpcis := GPCIs{}
+	for _, r := range records {
+		mac := cleanString(r[cols["mac"]])
+		if !isDigits(mac) {
+			continue
+		}
+		g := GPCI{
+			Source:         md.Source,
+			ExtractTime:    md.ExtractTime,
+			LastModified:   md.LastModified,
+			EffectiveDate:  md.EffectiveDate,
+			MAC:            mac,
+			State:          strings.Trim(cleanString(r[cols["state"]]), "*"),
+			LocalityNumber: localityNumber(r[cols["locality number"]]),
+			LocalityName:   strings.Trim(cleanString(r[cols["locality name"]]), "*"),
+		}
+		var err error
+		if g.WorkGPCI, err = ParseDecimal(r[cols["work gpci"]]); err != nil {
+			return nil, err
+		}
+		if g.PEGPCI, err = ParseDecimal(r[cols["pe gpci"]]); err != nil {
+			return nil, err
+		}
+		if g.MPGPCI, err = ParseDecimal(r[cols["mp gpci"]]); err != nil {
+			return nil, err
+		}
+		gpcis = append(gpcis, g)
+	}
+	return gpcis, nil
+}
+
+// localityNumber pads locality numbers to two digits, the files aren't consistent
+func localityNumber(s string) string {
+	s = cleanString(s)
+	if isDigits(s) && len(s) < 2 {
+		return "0" + s
+	}
+	return s
+}
+
+// CreateTable creates schema.table for GPCIs in postgres or sqlite (schema is ignored)
+func (g GPCIs) CreateTable(ctx context.Context, db *sqlx.DB, schema, table string) (sql.Result, error) {
+	names, err := dbTableNames(db, schema, table)
+	if err != nil {
+		return nil, err
+	}
+	q := `
+	create table if not exists %[1]s (
+		_source text,
+		_extract_time %[2]s,
+		_last_modified %[2]s,
+		_effective_date date not null,
+		mac text not null,
+		state text,
+		locality_number text not null,
+		locality_name text,
+		work_gpci %[3]s,
+		pe_gpci %[3]s,
+		mp_gpci %[3]s,
+		primary key (_effective_date, mac, locality_number)
+	)`
+	return createReferenceTable(ctx, db, names, q)
+}
+
+// Put writes g to schema.table, replacing the GPCIs of any locality already loaded for
+// the same release. It returns the number of rows written.
+func (g GPCIs) Put(ctx context.Context, db *sqlx.DB, schema, table string) (int64, error) {
+	names, err := dbTableNames(db, schema, table)
+	if err != nil {
+		return 0, err
+	}
+	return putReferenceRows(ctx, db, names, gpciColumns, gpciKey, g)
+}
@@ -0,0 +1,351 @@
+package cmsrvu
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jmoiron/sqlx"
+)
+
+// historyColumns are the value columns carried into the history table - a new version
+// of an hcpcs/modifier/variant is only opened when one of these changes between
+// releases
+var historyColumns = []string{
+	"modifier",
+	"description",
+	"status_code",
+	"status",
+	"wrvu",
+	"nonfacility_pervu",
+	"nonfacility_na_indicator",
+	"facility_pervu",
+	"facility_na_indicator",
+	"malpractice_rvu",
+	"total_nonfacility_rvu",
+	"total_facility_rvu",
+	"pctc_indicator",
+	"pctc",
+	"global_surgery_code",
+	"global_surgery",
+	"preoperative_surgery",
+	"intraoperative_surgery",
+	"postoperative_surgery",
+	"multiple_procedure_code",
+	"multiple_procedure",
+	"bilateral_surgery_code",
+	"bilateral_surgery",
+	"assistant_at_surgery_code",
+	"assistant_at_surgery",
+	"cosurgeons_code",
+	"cosurgeons",
+	"team_surgery_code",
+	"team_surgery",
+	"endoscopic_base_code",
+	"conversion_factor",
+	"physician_supervision_of_diagnostic_procedures_code",
+	"physician_supervision_of_diagnostic_procedures",
+	"calculation_flag",
+	"diagnostic_imaging_family_indicator",
+	"diagnostic_imaging_family",
+	"nonfacility_pe_used_for_opps_payment_amount",
+	"facility_pe_used_for_opps_payment_amount",
+	"malpractice_used_for_opps_payment_amount",
+}
+
+// ErrEmptyRelease is returned by UpdatePostgresHistory when the release has no rows
+var ErrEmptyRelease = errors.New("release has no rows")
+
+// historyHashExpr hashes the value columns of alias so versions can be compared in sql
+func historyHashExpr(alias string) string {
+	return fmt.Sprintf("md5(row(%s)::text)", prefixColumns(alias, historyColumns))
+}
+
+// historyReleaseRows selects one row per hcpcs/modifier/variant for every release in
+// table - if a release was loaded more than once the most recent extract wins. Rows
+// loaded before _variant existed have a null variant, _variant_key is 0 for them.
+func historyReleaseRows(names tableNames) string {
+	return fmt.Sprintf(`
+	select distinct on (_effective_date, hcpcs, modifier_code, coalesce(_variant, 0))
+		*, coalesce(_variant, 0) as _variant_key
+	from %s
+	order by _effective_date, hcpcs, modifier_code, coalesce(_variant, 0), _extract_time desc`,
+		names.qualified(""),
+	)
+}
+
+// CreatePostgresHistoryTable creates a slowly changing dimension (type 2) table named
+// after table, ie rvu -> rvu_history. Each row is a version of an hcpcs/modifier (and
+// _variant, when the code repeats in a release) that is valid from valid_from up to,
+// but not including, valid_to (null while current). To find the values in effect on a
+// date of service:
+//
+//	select * from cmsrvu.rvu_history
+//	where hcpcs = $1 and valid_from <= $2 and (valid_to is null or $2 < valid_to)
+//
+// History tables created before _variant was part of the key are migrated and rebuilt,
+// see migratePostgresHistoryTable.
+func (r RelativeValueUnits) CreatePostgresHistoryTable(ctx context.Context, db *sqlx.DB, schema, table string) (sql.Result, error) {
+	names, err := newTableNames(schema, table)
+	if err != nil {
+		return nil, err
+	}
+	q := `
+	create table if not exists %[2]s (
+		hcpcs text not null,
+		modifier_code text,
+		_variant int not null default 0,
+		valid_from date not null,
+		valid_to date,
+		_content_hash text not null,
+		_source text,
+		modifier text,
+		description text,
+		status_code text,
+		status text,
+		wrvu numeric,
+		nonfacility_pervu numeric,
+		nonfacility_na_indicator boolean,
+		facility_pervu numeric,
+		facility_na_indicator boolean,
+		malpractice_rvu numeric,
+		total_nonfacility_rvu numeric,
+		total_facility_rvu numeric,
+		pctc_indicator int,
+		pctc text,
+		global_surgery_code text,
+		global_surgery text,
+		preoperative_surgery numeric,
+		intraoperative_surgery numeric,
+		postoperative_surgery numeric,
+		multiple_procedure_code int,
+		multiple_procedure text,
+		bilateral_surgery_code int,
+		bilateral_surgery text,
+		assistant_at_surgery_code int,
+		assistant_at_surgery text,
+		cosurgeons_code int,
+		cosurgeons text,
+		team_surgery_code int,
+		team_surgery text,
+		endoscopic_base_code text,
+		conversion_factor numeric,
+		physician_supervision_of_diagnostic_procedures_code text,
+		physician_supervision_of_diagnostic_procedures text,
+		calculation_flag int,
+		diagnostic_imaging_family_indicator int,
+		diagnostic_imaging_family text,
+		nonfacility_pe_used_for_opps_payment_amount numeric,
+		facility_pe_used_for_opps_payment_amount numeric,
+		malpractice_used_for_opps_payment_amount numeric
+	)`
+	res, err := db.ExecContext(ctx, fmt.Sprintf(q, names.qualified(""), names.qualified("_history")))
+	if err != nil {
+		return nil, err
+	}
+	if err := migratePostgresHistoryTable(ctx, db, names); err != nil {
+		return nil, err
+	}
+	q = `
+	create unique index if not exists %[2]s
+		on %[1]s (hcpcs, coalesce(modifier_code, ''), _variant, valid_from)`
+	if _, err := db.ExecContext(ctx, fmt.Sprintf(q, names.qualified("_history"), names.name("_history_key_idx"))); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// migratePostgresHistoryTable adds _variant to history tables created before it was
+// part of the key. Their key index doesn't include it, so it's dropped (to be created
+// again with _variant) and the history is rebuilt to split out the variants that were
+// merged into one version.
+func migratePostgresHistoryTable(ctx context.Context, db *sqlx.DB, names tableNames) error {
+	var stale bool
+	q := `select coalesce(pg_get_indexdef(to_regclass($1)) not like '%_variant%', false)`
+	if err := db.QueryRowContext(ctx, q, names.qualified("_history_key_idx")).Scan(&stale); err != nil {
+		return err
+	}
+	if !stale {
+		return nil
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	q = `
+	alter table %[1]s add column if not exists _variant int not null default 0;
+	drop index %[2]s`
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(q, names.qualified("_history"), names.qualified("_history_key_idx"))); err != nil {
+		return err
+	}
+	if err := rebuildPostgresHistory(ctx, tx, names.schema, names.table); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UpdatePostgresHistory brings the history table up to date with the release in table
+// effective on effectiveDate. Loading releases in order only touches the versions that
+// changed; if the release is older than what the history already covers (a backfill or
+// a correction to an earlier release) the history is rebuilt from table instead. It
+// returns ErrEmptyRelease if table has no rows for effectiveDate, rather than closing
+// every current version.
+func (r RelativeValueUnits) UpdatePostgresHistory(ctx context.Context, db *sqlx.DB, schema, table string, effectiveDate pgtype.Date) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := updatePostgresHistory(ctx, tx, schema, table, effectiveDate); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// updatePostgresHistory is UpdatePostgresHistory in tx, so it can run in the same
+// transaction as the load
+func updatePostgresHistory(ctx context.Context, tx *sqlx.Tx, schema, table string, effectiveDate pgtype.Date) error {
+	names, err := newTableNames(schema, table)
+	if err != nil {
+		return err
+	}
+	var loaded, rebuild bool
+	q := `
+	select
+		exists (select 1 from %[1]s where _effective_date = $1),
+		exists (select 1 from %[1]s where _effective_date > $1)
+		or exists (select 1 from %[2]s where valid_from >= $1)`
+	if err := tx.QueryRowContext(ctx, fmt.Sprintf(q, names.qualified(""), names.qualified("_history")), effectiveDate).Scan(&loaded, &rebuild); err != nil {
+		return err
+	}
+	if !loaded {
+		return fmt.Errorf("%w: %s", ErrEmptyRelease, effectiveDate.Time.Format(time.DateOnly))
+	}
+	if rebuild {
+		return rebuildPostgresHistory(ctx, tx, schema, table)
+	}
+
+	cols := strings.Join(historyColumns, ", ")
+	release := fmt.Sprintf(
+		"select * from (%s) rr where rr._effective_date = $1",
+		historyReleaseRows(names),
+	)
+
+	// close current versions that changed or were dropped from the release
+	q = `
+	update %[2]s h
+	set valid_to = $1
+	where h.valid_to is null
+	and not exists (
+		select 1 from (%[3]s) r
+		where r.hcpcs = h.hcpcs
+		and r.modifier_code is not distinct from h.modifier_code
+		and r._variant_key = h._variant
+		and %[4]s = h._content_hash
+	)`
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(q, names.qualified(""), names.qualified("_history"), release, historyHashExpr("r")), effectiveDate); err != nil {
+		return err
+	}
+
+	// open versions for anything that doesn't have a current one
+	q = `
+	insert into %[2]s (hcpcs, modifier_code, _variant, valid_from, valid_to, _content_hash, _source, %[5]s)
+	select r.hcpcs, r.modifier_code, r._variant_key, r._effective_date, null, %[4]s, r._source, %[6]s
+	from (%[3]s) r
+	where not exists (
+		select 1 from %[2]s h
+		where h.valid_to is null
+		and h.hcpcs = r.hcpcs
+		and h.modifier_code is not distinct from r.modifier_code
+		and h._variant = r._variant_key
+	)`
+	q = fmt.Sprintf(q, names.qualified(""), names.qualified("_history"), release, historyHashExpr("r"), cols, prefixColumns("r", historyColumns))
+	_, err = tx.ExecContext(ctx, q, effectiveDate)
+	return err
+}
+
+// RebuildPostgresHistory replaces the contents of the history table with versions
+// derived from every release in table. A version ends when the values change, or when
+// the hcpcs/modifier/variant is missing from the next release.
+func (r RelativeValueUnits) RebuildPostgresHistory(ctx context.Context, db *sqlx.DB, schema, table string) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := rebuildPostgresHistory(ctx, tx, schema, table); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func rebuildPostgresHistory(ctx context.Context, tx *sqlx.Tx, schema, table string) error {
+	names, err := newTableNames(schema, table)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "delete from "+names.qualified("_history")); err != nil {
+		return err
+	}
+
+	q := `
+	with releases as (
+		select _effective_date, lead(_effective_date) over (order by _effective_date) as next_date
+		from (select distinct _effective_date from %[1]s) d
+	), hashed as (
+		select t.*, r.next_date, %[4]s as _content_hash
+		from (%[3]s) t
+		join releases r on r._effective_date = t._effective_date
+	), flagged as (
+		select h.*,
+			case
+				when lag(h._content_hash) over w is distinct from h._content_hash then 1
+				when lag(h.next_date) over w is distinct from h._effective_date then 1
+				else 0
+			end as new_version
+		from hashed h
+		window w as (partition by h.hcpcs, h.modifier_code, h._variant_key order by h._effective_date)
+	), islands as (
+		select f.*,
+			sum(f.new_version) over (
+				partition by f.hcpcs, f.modifier_code, f._variant_key order by f._effective_date
+			) as island
+		from flagged f
+	)
+	insert into %[2]s (hcpcs, modifier_code, _variant, valid_from, valid_to, _content_hash, _source, %[5]s)
+	select distinct on (i.hcpcs, i.modifier_code, i._variant_key, i.island)
+		i.hcpcs,
+		i.modifier_code,
+		i._variant_key,
+		i._effective_date,
+		last_value(i.next_date) over (
+			partition by i.hcpcs, i.modifier_code, i._variant_key, i.island
+			order by i._effective_date
+			rows between unbounded preceding and unbounded following
+		),
+		i._content_hash,
+		i._source,
+		%[6]s
+	from islands i
+	order by i.hcpcs, i.modifier_code, i._variant_key, i.island, i._effective_date`
+	q = fmt.Sprintf(q, names.qualified(""), names.qualified("_history"), historyReleaseRows(names), historyHashExpr("t"),
+		strings.Join(historyColumns, ", "), prefixColumns("i", historyColumns))
+	_, err = tx.ExecContext(ctx, q)
+	return err
+}
+
+func prefixColumns(alias string, columns []string) string {
+	cols := make([]string, len(columns))
+	for i, c := range columns {
+		cols[i] = alias + "." + c
+	}
+	return strings.Join(cols, ", ")
+}
@@ -0,0 +1,136 @@
+package cmsrvu
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"unicode/utf8"
+)
+
+// maxIdentifierLength is postgres' limit, longer names are silently truncated which
+// could make two tables collide
+const maxIdentifierLength = 63
+
+// tableSuffixes are appended to a table's name for the tables, views, indexes, etc
+// derived from it. They're validated along with the table name so a long name fails
+// up front rather than part way through creating a schema.
+var tableSuffixes = []string{
+	"_history",
+	"_history_key_idx",
+	"_change_log",
+	"_hcpcs_idx",
+	"_natural_key_idx",
+	"_as_of",
+	"_current",
+	"_national_payment",
+	"_national_payment_key_idx",
+	"_0000", // yearly partitions
+}
+
+// ValidateIdentifier checks that name can be used as a schema or table name. Any
+// characters are allowed since names are always quoted, but names can't be empty,
+// contain NUL characters or be longer than postgres allows.
+func ValidateIdentifier(name string) error {
+	switch {
+	case name == "":
+		return errors.New("identifier cannot be empty")
+	case !utf8.ValidString(name):
+		return fmt.Errorf("identifier %q is not valid utf-8", name)
+	case strings.ContainsRune(name, 0):
+		return fmt.Errorf("identifier %q contains a NUL character", name)
+	case len(name) > maxIdentifierLength:
+		return fmt.Errorf("identifier %q is longer than %d bytes", name, maxIdentifierLength)
+	}
+	return nil
+}
+
+// QuoteIdentifier quotes name for use in postgres or sqlite, embedded quotes are doubled
+func QuoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// tableNames builds quoted names for a table and the objects derived from it
+type tableNames struct {
+	schema string // empty for sqlite
+	table  string
+}
+
+// newTableNames validates schema and table, schema defaults to cmsrvu
+func newTableNames(schema, table string) (tableNames, error) {
+	if schema == "" {
+		schema = "cmsrvu"
+	}
+	if err := ValidateIdentifier(schema); err != nil {
+		return tableNames{}, err
+	}
+	n, err := newSQLiteTableNames(table)
+	n.schema = schema
+	return n, err
+}
+
+// newSQLiteTableNames validates table, sqlite doesn't have schemas
+func newSQLiteTableNames(table string) (tableNames, error) {
+	for _, suffix := range append([]string{""}, tableSuffixes...) {
+		if err := ValidateIdentifier(table + suffix); err != nil {
+			return tableNames{}, err
+		}
+	}
+	return tableNames{table: table}, nil
+}
+
+// qualified returns the quoted, schema qualified name of table+suffix
+func (n tableNames) qualified(suffix string) string {
+	if n.schema == "" {
+		return n.name(suffix)
+	}
+	return QuoteIdentifier(n.schema) + "." + n.name(suffix)
+}
+
+// name returns the quoted, unqualified name of table+suffix, ie for naming indexes
+func (n tableNames) name(suffix string) string {
+	return QuoteIdentifier(n.table + suffix)
+}
+
+// quotedSchema returns the quoted schema name
+func (n tableNames) quotedSchema() string {
+	return QuoteIdentifier(n.schema)
+}
+
+// DefaultTableNameTemplate leaves table names as they are
+const DefaultTableNameTemplate = "{{.Name}}"
+
+// tableNameData is passed to DBConfig.TableNameTemplate
+type tableNameData struct {
+	Name   string // the table's base name, ie rvu or load_log
+	Schema string
+}
+
+// TableName renders DBConfig.TableNameTemplate for the table called name, ie rvu. The
+// template is a text/template that can use .Name, .Schema and an env function, so
+// teams sharing a database can prefix their tables:
+//
+//	TableNameTemplate: '{{env "CMSRVU_ENV"}}_{{.Name}}'
+//
+// The result is validated with ValidateIdentifier.
+func (c DBConfig) TableName(name string) (string, error) {
+	text := c.TableNameTemplate
+	if text == "" {
+		text = DefaultTableNameTemplate
+	}
+	tmpl, err := template.New("table").
+		Funcs(template.FuncMap{"env": os.Getenv}).
+		Option("missingkey=error").
+		Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, tableNameData{Name: name, Schema: c.Schema}); err != nil {
+		return "", err
+	}
+	out := strings.TrimSpace(b.String())
+	return out, ValidateIdentifier(out)
+}
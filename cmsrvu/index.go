@@ -0,0 +1,261 @@
+package cmsrvu
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// RVUIndex answers "which rvus apply to this hcpcs and modifier on this date of
+// service" from memory, without a database. Lookups are a map access plus a binary
+// search over the releases. It is safe for concurrent use - lookups never block, and
+// Replace and AddReleases swap in a new index once it's built, so readers see either
+// the old releases or the new ones.
+type RVUIndex struct {
+	data atomic.Pointer[rvuIndexData]
+}
+
+type rvuKey struct {
+	hcpcs    string
+	modifier string // "" for no modifier
+}
+
+// rvuVariantKey identifies one of the rows of a repeated hcpcs/modifier, see
+// RelativeValueUnit.Variant
+type rvuVariantKey struct {
+	rvuKey
+	variant int64
+}
+
+type rvuIndexData struct {
+	releases []time.Time                           // effective dates, sorted
+	rvus     map[rvuVariantKey][]RelativeValueUnit // sorted by effective date, one per release
+	variants map[rvuKey]int64                      // the most variants in any release
+	len      int
+}
+
+// NewRVUIndex indexes rvus, which can span any number of releases
+func NewRVUIndex(rvus RelativeValueUnits) *RVUIndex {
+	x := &RVUIndex{}
+	x.Replace(rvus)
+	return x
+}
+
+// NewRVUIndexFromConfig downloads and indexes every release in cfg.Data, see GetRVUs
+func NewRVUIndexFromConfig(cfg Config) (*RVUIndex, error) {
+	all := RelativeValueUnits{}
+	for _, dc := range cfg.Data {
+		pattern := dc.FileRegex
+		if pattern == "" {
+			pattern = cfg.RVUFileRegex
+		}
+		rvus, err := GetRVUs(dc.URL, "", pattern, dc.EffectiveDate)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", dc.URL, err)
+		}
+		all = append(all, rvus...)
+	}
+	return NewRVUIndex(all), nil
+}
+
+// NewRVUIndexFromFiles indexes csv (see FileSink) and parquet (see ParquetSink)
+// exports, the format is chosen by the file extension
+func NewRVUIndexFromFiles(paths ...string) (*RVUIndex, error) {
+	all := RelativeValueUnits{}
+	for _, path := range paths {
+		rvus, err := readExport(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		all = append(all, rvus...)
+	}
+	return NewRVUIndex(all), nil
+}
+
+func readExport(path string) (RelativeValueUnits, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".csv":
+		return ReadCSV(f)
+	case ".parquet":
+		info, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		return ReadParquet(f, info.Size())
+	default:
+		return nil, fmt.Errorf("unsupported file type %q", ext)
+	}
+}
+
+// Replace swaps the contents of the index for rvus
+func (x *RVUIndex) Replace(rvus RelativeValueUnits) {
+	x.data.Store(newRVUIndexData(rvus))
+}
+
+// AddReleases adds the releases in rvus to the index, replacing any releases already
+// indexed with the same effective dates - ie when a new release loads
+func (x *RVUIndex) AddReleases(rvus RelativeValueUnits) {
+	for {
+		old := x.data.Load()
+		dates := map[int64]bool{}
+		for _, rvu := range rvus {
+			dates[rvu.EffectiveDate.Time.Unix()] = true
+		}
+		merged := slices.Clone(rvus)
+		if old != nil {
+			for _, versions := range old.rvus {
+				for _, rvu := range versions {
+					if !dates[rvu.EffectiveDate.Time.Unix()] {
+						merged = append(merged, rvu)
+					}
+				}
+			}
+		}
+		// start over if another writer swapped the index while we were merging
+		if x.data.CompareAndSwap(old, newRVUIndexData(merged)) {
+			return
+		}
+	}
+}
+
+// Lookup returns the rvus for hcpcs and modifier ("" for none) in the release in effect
+// on dateOfService - the latest release effective on or before it. ok is false if
+// there's no such release or the code isn't in it. If the hcpcs/modifier repeats in
+// the release it's the first row, variant 0 - see Variants for all of them.
+func (x *RVUIndex) Lookup(hcpcs, modifier string, dateOfService time.Time) (rvu RelativeValueUnit, ok bool) {
+	d := x.data.Load()
+	if d == nil {
+		return rvu, false
+	}
+	release, ok := d.release(dateOfService)
+	if !ok {
+		return rvu, false
+	}
+	return d.lookup(rvuVariantKey{rvuKey{hcpcs, modifier}, 0}, release)
+}
+
+// Variants returns every row for hcpcs and modifier in the release in effect on
+// dateOfService, by variant. It's nil if there's no such release or the code isn't in
+// it, and has one row unless the hcpcs/modifier repeats in the release.
+func (x *RVUIndex) Variants(hcpcs, modifier string, dateOfService time.Time) RelativeValueUnits {
+	d := x.data.Load()
+	if d == nil {
+		return nil
+	}
+	release, ok := d.release(dateOfService)
+	if !ok {
+		return nil
+	}
+	key := rvuKey{hcpcs, modifier}
+	var rvus RelativeValueUnits
+	for v := range d.variants[key] {
+		if rvu, ok := d.lookup(rvuVariantKey{key, v}, release); ok {
+			rvus = append(rvus, rvu)
+		}
+	}
+	return rvus
+}
+
+// History returns every indexed version of hcpcs and modifier, oldest first and by
+// variant within a release
+func (x *RVUIndex) History(hcpcs, modifier string) RelativeValueUnits {
+	d := x.data.Load()
+	if d == nil {
+		return nil
+	}
+	key := rvuKey{hcpcs, modifier}
+	var history RelativeValueUnits
+	for v := range d.variants[key] {
+		history = append(history, d.rvus[rvuVariantKey{key, v}]...)
+	}
+	slices.SortStableFunc(history, func(a, b RelativeValueUnit) int {
+		return a.EffectiveDate.Time.Compare(b.EffectiveDate.Time)
+	})
+	return history
+}
+
+// Releases returns the effective dates of the indexed releases, oldest first
+func (x *RVUIndex) Releases() []time.Time {
+	d := x.data.Load()
+	if d == nil {
+		return nil
+	}
+	return slices.Clone(d.releases)
+}
+
+// Len returns the number of indexed rvus
+func (x *RVUIndex) Len() int {
+	d := x.data.Load()
+	if d == nil {
+		return 0
+	}
+	return d.len
+}
+
+func newRVUIndexData(rvus RelativeValueUnits) *rvuIndexData {
+	d := &rvuIndexData{rvus: map[rvuVariantKey][]RelativeValueUnit{}, variants: map[rvuKey]int64{}}
+	for _, rvu := range rvus {
+		key := rvuVariantKey{rvuKey{rvu.HCPCS, rvu.ModifierCode.String}, rvu.Variant}
+		d.rvus[key] = append(d.rvus[key], rvu)
+		d.variants[key.rvuKey] = max(d.variants[key.rvuKey], rvu.Variant+1)
+		d.releases = append(d.releases, rvu.EffectiveDate.Time)
+	}
+	slices.SortFunc(d.releases, time.Time.Compare)
+	d.releases = slices.CompactFunc(d.releases, time.Time.Equal)
+
+	for key, versions := range d.rvus {
+		// if a release was loaded more than once the latest extract wins
+		slices.SortStableFunc(versions, func(a, b RelativeValueUnit) int {
+			if c := a.EffectiveDate.Time.Compare(b.EffectiveDate.Time); c != 0 {
+				return c
+			}
+			return b.ExtractTime.Compare(a.ExtractTime)
+		})
+		versions = slices.CompactFunc(versions, func(a, b RelativeValueUnit) bool {
+			return a.EffectiveDate.Time.Equal(b.EffectiveDate.Time)
+		})
+		d.rvus[key] = versions
+		d.len += len(versions)
+	}
+	return d
+}
+
+// lookup returns key's row in release
+func (d *rvuIndexData) lookup(key rvuVariantKey, release time.Time) (RelativeValueUnit, bool) {
+	versions := d.rvus[key]
+	i, ok := slices.BinarySearchFunc(versions, release, func(r RelativeValueUnit, t time.Time) int {
+		return r.EffectiveDate.Time.Compare(t)
+	})
+	if !ok {
+		return RelativeValueUnit{}, false
+	}
+	return versions[i], true
+}
+
+// release returns the effective date of the release in effect on dateOfService
+func (d *rvuIndexData) release(dateOfService time.Time) (time.Time, bool) {
+	return releaseOn(d.releases, dateOfService)
+}
+
+// releaseOn returns the latest of releases, sorted effective dates, that's effective
+// on or before dateOfService
+func releaseOn(releases []time.Time, dateOfService time.Time) (time.Time, bool) {
+	dos := time.Date(dateOfService.Year(), dateOfService.Month(), dateOfService.Day(), 0, 0, 0, 0, time.UTC)
+	i, found := slices.BinarySearchFunc(releases, dos, time.Time.Compare)
+	if found {
+		return releases[i], true
+	}
+	if i == 0 {
+		return time.Time{}, false
+	}
+	return releases[i-1], true
+}
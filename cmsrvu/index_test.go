@@ -0,0 +1,35 @@
+package cmsrvu
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRVUIndexVariants(t *testing.T) {
+	// the 99213 row repeated with another description, as a correction file might
+	rvus := rvusFromCSV(t, pprrvu24Jul+
+		"99213,,Office o/p est low 20 min (repeat),A,,1.30,1.33,,0.56,,0.10,2.73,1.96,0,XXX,0.00,0.00,0.00,0,0,0,0,0,,33.2875,09,0,99,0.00,0.00,0.00\n")
+	x := NewRVUIndex(rvus)
+	dos := time.Date(2024, 8, 1, 0, 0, 0, 0, time.UTC)
+
+	if got := x.Len(); got != len(rvus) {
+		t.Errorf("Len = %d, want %d", got, len(rvus))
+	}
+	rvu, ok := x.Lookup("99213", "", dos)
+	if !ok || rvu.Variant != 0 || rvu.Description.String != "Office o/p est low 20 min" {
+		t.Errorf("Lookup = %d %q, %t, want variant 0", rvu.Variant, rvu.Description.String, ok)
+	}
+	variants := x.Variants("99213", "", dos)
+	if len(variants) != 2 || variants[0].Variant != 0 || variants[1].Variant != 1 {
+		t.Errorf("Variants returned %d rows, want variants 0 and 1", len(variants))
+	}
+	if got := len(x.History("99213", "")); got != 2 {
+		t.Errorf("History returned %d rows, want 2", got)
+	}
+	if got := len(x.Variants("70450", "TC", dos)); got != 1 {
+		t.Errorf("Variants(70450 TC) returned %d rows, want 1", got)
+	}
+	if got := x.Variants("99213", "", time.Date(2024, 6, 30, 0, 0, 0, 0, time.UTC)); got != nil {
+		t.Errorf("Variants before the release = %d rows, want none", len(got))
+	}
+}
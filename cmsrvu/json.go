@@ -0,0 +1,222 @@
+package cmsrvu
+
+import (
+	_ "embed"
+	"encoding/json"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// RVUJSONSchema is a JSON Schema (draft 2020-12) for the JSON encoding of a
+// RelativeValueUnit
+//
+//go:embed rvu.schema.json
+var RVUJSONSchema []byte
+
+// jsonRVU is the JSON and YAML layout of a RelativeValueUnit. Names match the db
+// columns, sql.Null* fields and Decimals are null when they aren't valid and the
+// effective date is yyyy-mm-dd. Each code is followed by its label, as in the db.
+type jsonRVU struct {
+	IDHash                                         string    `json:"_id_hash" yaml:"_id_hash"`
+	NaturalKey                                     string    `json:"_natural_key" yaml:"_natural_key"`
+	Variant                                        int64     `json:"_variant" yaml:"_variant"`
+	ContentHash                                    string    `json:"_content_hash" yaml:"_content_hash"`
+	HashVersion                                    string    `json:"_hash_version" yaml:"_hash_version"`
+	Source                                         string    `json:"_source" yaml:"_source"`
+	ExtractTime                                    time.Time `json:"_extract_time" yaml:"_extract_time"`
+	LastModified                                   time.Time `json:"_last_modified" yaml:"_last_modified"`
+	EffectiveDate                                  string    `json:"_effective_date" yaml:"_effective_date"`
+	HCPCS                                          string    `json:"hcpcs" yaml:"hcpcs"`
+	ModifierCode                                   *string   `json:"modifier_code" yaml:"modifier_code"`
+	Modifier                                       *string   `json:"modifier" yaml:"modifier"`
+	Description                                    *string   `json:"description" yaml:"description"`
+	StatusCode                                     *string   `json:"status_code" yaml:"status_code"`
+	Status                                         *string   `json:"status" yaml:"status"`
+	WRVU                                           Decimal   `json:"wrvu" yaml:"wrvu"`
+	NonFacilityPERVU                               Decimal   `json:"nonfacility_pervu" yaml:"nonfacility_pervu"`
+	NonFacilityNAIndicator                         bool      `json:"nonfacility_na_indicator" yaml:"nonfacility_na_indicator"`
+	FacilityPERVU                                  Decimal   `json:"facility_pervu" yaml:"facility_pervu"`
+	FacilityNAIndicator                            bool      `json:"facility_na_indicator" yaml:"facility_na_indicator"`
+	MalpracticeRVU                                 Decimal   `json:"malpractice_rvu" yaml:"malpractice_rvu"`
+	TotalNonFacilityRVU                            Decimal   `json:"total_nonfacility_rvu" yaml:"total_nonfacility_rvu"`
+	TotalFacilityRVU                               Decimal   `json:"total_facility_rvu" yaml:"total_facility_rvu"`
+	PCTCIndicator                                  *int64    `json:"pctc_indicator" yaml:"pctc_indicator"`
+	PCTC                                           *string   `json:"pctc" yaml:"pctc"`
+	GlobalSurgeryCode                              *string   `json:"global_surgery_code" yaml:"global_surgery_code"`
+	GlobalSurgery                                  *string   `json:"global_surgery" yaml:"global_surgery"`
+	PreoperativePercentage                         *float64  `json:"preoperative_surgery" yaml:"preoperative_surgery"`
+	IntraoperativePercentage                       *float64  `json:"intraoperative_surgery" yaml:"intraoperative_surgery"`
+	PostoperativePercentage                        *float64  `json:"postoperative_surgery" yaml:"postoperative_surgery"`
+	MultipleProcedureCode                          *int64    `json:"multiple_procedure_code" yaml:"multiple_procedure_code"`
+	MultipleProcedure                              *string   `json:"multiple_procedure" yaml:"multiple_procedure"`
+	BilateralSurgeryCode                           *int64    `json:"bilateral_surgery_code" yaml:"bilateral_surgery_code"`
+	BilateralSurgery                               *string   `json:"bilateral_surgery" yaml:"bilateral_surgery"`
+	AssistantAtSurgeryCode                         *int64    `json:"assistant_at_surgery_code" yaml:"assistant_at_surgery_code"`
+	AssistantAtSurgery                             *string   `json:"assistant_at_surgery" yaml:"assistant_at_surgery"`
+	CoSurgeonsCode                                 *int64    `json:"cosurgeons_code" yaml:"cosurgeons_code"`
+	CoSurgeons                                     *string   `json:"cosurgeons" yaml:"cosurgeons"`
+	TeamSurgeryCode                                *int64    `json:"team_surgery_code" yaml:"team_surgery_code"`
+	TeamSurgery                                    *string   `json:"team_surgery" yaml:"team_surgery"`
+	EndoscopicBaseCode                             *string   `json:"endoscopic_base_code" yaml:"endoscopic_base_code"`
+	ConversionFactor                               Decimal   `json:"conversion_factor" yaml:"conversion_factor"`
+	PhysicianSupervisionOfDiagnosticProceduresCode *string   `json:"physician_supervision_of_diagnostic_procedures_code" yaml:"physician_supervision_of_diagnostic_procedures_code"`
+	PhysicianSupervisionOfDiagnosticProcedures     *string   `json:"physician_supervision_of_diagnostic_procedures" yaml:"physician_supervision_of_diagnostic_procedures"`
+	CalculationFlag                                *int64    `json:"calculation_flag" yaml:"calculation_flag"`
+	DiagnosticImagingFamilyIndicator               *int64    `json:"diagnostic_imaging_family_indicator" yaml:"diagnostic_imaging_family_indicator"`
+	DiagnosticImagingFamily                        *string   `json:"diagnostic_imaging_family" yaml:"diagnostic_imaging_family"`
+	NonFacilityPEUsedForOppsPaymentAmount          Decimal   `json:"nonfacility_pe_used_for_opps_payment_amount" yaml:"nonfacility_pe_used_for_opps_payment_amount"`
+	FacilityPEUsedForOppsPaymentAmount             Decimal   `json:"facility_pe_used_for_opps_payment_amount" yaml:"facility_pe_used_for_opps_payment_amount"`
+	MalpracticeUsedForOppsPaymentAmount            Decimal   `json:"malpractice_used_for_opps_payment_amount" yaml:"malpractice_used_for_opps_payment_amount"`
+}
+
+func toJSONRVU(r RelativeValueUnit) jsonRVU {
+	return jsonRVU{
+		IDHash:                   r.IDHash,
+		NaturalKey:               r.NaturalKey,
+		Variant:                  r.Variant,
+		ContentHash:              r.ContentHash,
+		HashVersion:              r.HashVersion,
+		Source:                   r.Source,
+		ExtractTime:              r.ExtractTime,
+		LastModified:             r.LastModified,
+		EffectiveDate:            r.EffectiveDate.Time.Format(time.DateOnly),
+		HCPCS:                    r.HCPCS,
+		ModifierCode:             nullStringPtr(r.ModifierCode),
+		Modifier:                 nullStringPtr(r.Modifier),
+		Description:              nullStringPtr(r.Description),
+		StatusCode:               nullStringPtr(r.StatusCode.NullString),
+		Status:                   nullStringPtr(r.Status),
+		WRVU:                     r.WRVU,
+		NonFacilityPERVU:         r.NonFacilityPERVU,
+		NonFacilityNAIndicator:   r.NonFacilityNAIndicator,
+		FacilityPERVU:            r.FacilityPERVU,
+		FacilityNAIndicator:      r.FacilityNAIndicator,
+		MalpracticeRVU:           r.MalpracticeRVU,
+		TotalNonFacilityRVU:      r.TotalNonFacilityRVU,
+		TotalFacilityRVU:         r.TotalFacilityRVU,
+		PCTCIndicator:            nullInt64Ptr(r.PCTCIndicator.NullInt64),
+		PCTC:                     nullStringPtr(r.PCTC),
+		GlobalSurgeryCode:        nullStringPtr(r.GlobalSurgeryCode.NullString),
+		GlobalSurgery:            nullStringPtr(r.GlobalSurgery),
+		PreoperativePercentage:   nullFloat64Ptr(r.PreoperativePercentage),
+		IntraoperativePercentage: nullFloat64Ptr(r.IntraoperativePercentage),
+		PostoperativePercentage:  nullFloat64Ptr(r.PostoperativePercentage),
+		MultipleProcedureCode:    nullInt64Ptr(r.MultipleProcedureCode.NullInt64),
+		MultipleProcedure:        nullStringPtr(r.MultipleProcedure),
+		BilateralSurgeryCode:     nullInt64Ptr(r.BilateralSurgeryCode.NullInt64),
+		BilateralSurgery:         nullStringPtr(r.BilateralSurgery),
+		AssistantAtSurgeryCode:   nullInt64Ptr(r.AssistantAtSurgeryCode.NullInt64),
+		AssistantAtSurgery:       nullStringPtr(r.AssistantAtSurgery),
+		CoSurgeonsCode:           nullInt64Ptr(r.CoSurgeonsCode.NullInt64),
+		CoSurgeons:               nullStringPtr(r.CoSurgeons),
+		TeamSurgeryCode:          nullInt64Ptr(r.TeamSurgeryCode.NullInt64),
+		TeamSurgery:              nullStringPtr(r.TeamSurgery),
+		EndoscopicBaseCode:       nullStringPtr(r.EndoscopicBaseCode),
+		ConversionFactor:         r.ConversionFactor,
+		PhysicianSupervisionOfDiagnosticProceduresCode: nullStringPtr(r.PhysicianSupervisionOfDiagnosticProceduresCode.NullString),
+		PhysicianSupervisionOfDiagnosticProcedures:     nullStringPtr(r.PhysicianSupervisionOfDiagnosticProcedures),
+		CalculationFlag:                       nullInt64Ptr(r.CalculationFlag),
+		DiagnosticImagingFamilyIndicator:      nullInt64Ptr(r.DiagnosticImagingFamilyIndicator.NullInt64),
+		DiagnosticImagingFamily:               nullStringPtr(r.DiagnosticImagingFamily),
+		NonFacilityPEUsedForOppsPaymentAmount: r.NonFacilityPEUsedForOppsPaymentAmount,
+		FacilityPEUsedForOppsPaymentAmount:    r.FacilityPEUsedForOppsPaymentAmount,
+		MalpracticeUsedForOppsPaymentAmount:   r.MalpracticeUsedForOppsPaymentAmount,
+	}
+}
+
+func fromJSONRVU(j jsonRVU) (RelativeValueUnit, error) {
+	effectiveDate, err := time.Parse(time.DateOnly, j.EffectiveDate)
+	if err != nil {
+		return RelativeValueUnit{}, err
+	}
+	return RelativeValueUnit{
+		IDHash:                   j.IDHash,
+		NaturalKey:               j.NaturalKey,
+		Variant:                  j.Variant,
+		ContentHash:              j.ContentHash,
+		HashVersion:              j.HashVersion,
+		Source:                   j.Source,
+		ExtractTime:              j.ExtractTime,
+		LastModified:             j.LastModified,
+		EffectiveDate:            pgtype.Date{Time: effectiveDate, Valid: true},
+		HCPCS:                    j.HCPCS,
+		ModifierCode:             ptrNullString(j.ModifierCode),
+		Modifier:                 ptrNullString(j.Modifier),
+		Description:              ptrNullString(j.Description),
+		StatusCode:               StatusCode{ptrNullString(j.StatusCode)},
+		Status:                   ptrNullString(j.Status),
+		WRVU:                     j.WRVU,
+		NonFacilityPERVU:         j.NonFacilityPERVU,
+		NonFacilityNAIndicator:   j.NonFacilityNAIndicator,
+		FacilityPERVU:            j.FacilityPERVU,
+		FacilityNAIndicator:      j.FacilityNAIndicator,
+		MalpracticeRVU:           j.MalpracticeRVU,
+		TotalNonFacilityRVU:      j.TotalNonFacilityRVU,
+		TotalFacilityRVU:         j.TotalFacilityRVU,
+		PCTCIndicator:            PCTCIndicator{ptrNullInt64(j.PCTCIndicator)},
+		PCTC:                     ptrNullString(j.PCTC),
+		GlobalSurgeryCode:        GlobalPeriod{ptrNullString(j.GlobalSurgeryCode)},
+		GlobalSurgery:            ptrNullString(j.GlobalSurgery),
+		PreoperativePercentage:   ptrNullFloat64(j.PreoperativePercentage),
+		IntraoperativePercentage: ptrNullFloat64(j.IntraoperativePercentage),
+		PostoperativePercentage:  ptrNullFloat64(j.PostoperativePercentage),
+		MultipleProcedureCode:    MultipleProcedureIndicator{ptrNullInt64(j.MultipleProcedureCode)},
+		MultipleProcedure:        ptrNullString(j.MultipleProcedure),
+		BilateralSurgeryCode:     BilateralSurgeryIndicator{ptrNullInt64(j.BilateralSurgeryCode)},
+		BilateralSurgery:         ptrNullString(j.BilateralSurgery),
+		AssistantAtSurgeryCode:   AssistantAtSurgeryIndicator{ptrNullInt64(j.AssistantAtSurgeryCode)},
+		AssistantAtSurgery:       ptrNullString(j.AssistantAtSurgery),
+		CoSurgeonsCode:           CoSurgeonsIndicator{ptrNullInt64(j.CoSurgeonsCode)},
+		CoSurgeons:               ptrNullString(j.CoSurgeons),
+		TeamSurgeryCode:          TeamSurgeryIndicator{ptrNullInt64(j.TeamSurgeryCode)},
+		TeamSurgery:              ptrNullString(j.TeamSurgery),
+		EndoscopicBaseCode:       ptrNullString(j.EndoscopicBaseCode),
+		ConversionFactor:         j.ConversionFactor,
+		PhysicianSupervisionOfDiagnosticProceduresCode: SupervisionCode{ptrNullString(j.PhysicianSupervisionOfDiagnosticProceduresCode)},
+		PhysicianSupervisionOfDiagnosticProcedures:     ptrNullString(j.PhysicianSupervisionOfDiagnosticProcedures),
+		CalculationFlag:                       ptrNullInt64(j.CalculationFlag),
+		DiagnosticImagingFamilyIndicator:      ImagingFamilyIndicator{ptrNullInt64(j.DiagnosticImagingFamilyIndicator)},
+		DiagnosticImagingFamily:               ptrNullString(j.DiagnosticImagingFamily),
+		NonFacilityPEUsedForOppsPaymentAmount: j.NonFacilityPEUsedForOppsPaymentAmount,
+		FacilityPEUsedForOppsPaymentAmount:    j.FacilityPEUsedForOppsPaymentAmount,
+		MalpracticeUsedForOppsPaymentAmount:   j.MalpracticeUsedForOppsPaymentAmount,
+	}, nil
+}
+
+// MarshalJSON encodes r with snake_case names matching the db columns, see
+// RVUJSONSchema
+func (r RelativeValueUnit) MarshalJSON() ([]byte, error) {
+	return json.Marshal(toJSONRVU(r))
+}
+
+func (r *RelativeValueUnit) UnmarshalJSON(b []byte) error {
+	var j jsonRVU
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	rvu, err := fromJSONRVU(j)
+	if err != nil {
+		return err
+	}
+	*r = rvu
+	return nil
+}
+
+// MarshalYAML encodes r with the same names and values as MarshalJSON
+func (r RelativeValueUnit) MarshalYAML() (any, error) {
+	return toJSONRVU(r), nil
+}
+
+func (r *RelativeValueUnit) UnmarshalYAML(unmarshal func(any) error) error {
+	var j jsonRVU
+	if err := unmarshal(&j); err != nil {
+		return err
+	}
+	rvu, err := fromJSONRVU(j)
+	if err != nil {
+		return err
+	}
+	*r = rvu
+	return nil
+}
@@ -0,0 +1,458 @@
+package cmsrvu
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jmoiron/sqlx"
+)
+
+// LoadStatus is the outcome of a load as recorded in load_log
+type LoadStatus string
+
+const (
+	LoadStatusRunning LoadStatus = "running"
+	LoadStatusSuccess LoadStatus = "success"
+	LoadStatusFailed  LoadStatus = "failed"
+	LoadStatusSkipped LoadStatus = "skipped" // already loaded with the same checksum
+	LoadStatusDeleted LoadStatus = "deleted" // removed by DeleteRelease
+)
+
+// LoadLog is a row in the load_log table, there is one per attempt to load a release
+type LoadLog struct {
+	LoadID        int64          `db:"load_id"`
+	Source        string         `db:"source"`
+	EffectiveDate pgtype.Date    `db:"effective_date"`
+	Checksum      sql.NullString `db:"checksum"`
+	RowsParsed    int64          `db:"rows_parsed"` // rows with a status code, not blank lines or footnotes
+	RowsInserted  int64          `db:"rows_inserted"`
+	RowsRejected  int64          `db:"rows_rejected"` // rows that failed to parse, the load fails if there are any
+	RowsDeleted   int64          `db:"rows_deleted"`  // rows removed by DeleteRelease
+	StartTime     time.Time      `db:"start_time"`
+	EndTime       sql.NullTime   `db:"end_time"`
+	Status        LoadStatus     `db:"status"`
+	Error         sql.NullString `db:"error"`
+}
+
+// CreatePostgresLoadLogTable creates schema.table, which records every attempt to
+// load a release. table defaults to load_log.
+func CreatePostgresLoadLogTable(ctx context.Context, db *sqlx.DB, schema, table string) (sql.Result, error) {
+	if schema == "" {
+		schema = "cmsrvu"
+	}
+	name, err := loadLogName(schema, table)
+	if err != nil {
+		return nil, err
+	}
+	q := `
+	create table if not exists %[1]s (
+		load_id bigserial primary key,
+		source text not null,
+		effective_date date not null,
+		checksum text,
+		rows_parsed bigint not null default 0,
+		rows_inserted bigint not null default 0,
+		rows_rejected bigint not null default 0,
+		rows_deleted bigint not null default 0,
+		start_time timestamptz not null,
+		end_time timestamptz,
+		status text not null,
+		error text
+	);
+	alter table %[1]s add column if not exists rows_deleted bigint not null default 0`
+	return db.ExecContext(ctx, fmt.Sprintf(q, name))
+}
+
+// Loader downloads releases and writes them to Schema.Table, keeping the history table
+// and load log (LoadLog, defaults to load_log) up to date. Releases that were already
+// loaded successfully from an identical archive are skipped unless Force is set. Every
+// release that is loaded is also written to Sinks, ie a FileSink to export the
+// releases as they load.
+//
+// DB can be postgres or sqlite (see Open). Sqlite has no schemas so Schema is ignored,
+// and only supports LoadModeInsert - there is no change log, history table or as_of
+// function (use QueryRVUs with a DateOfService), and the _current and
+// _national_payment views are plain views, see CreateSQLiteViews.
+// Partitioned only applies to postgres, see CreatePartitionedPostgresTable.
+//
+// The reference files in each archive are loaded too if their table is set, ie
+// GPCITable for the GPCI file, LocalityCountyTable for the LOCCO file,
+// AnesthesiaCFTable for the ANES file and OPPSCapTable for the OPPSCAP file. They're
+// replaced whenever the release is loaded, and loaded on their own if the release is
+// skipped but they haven't been loaded from it yet, ie when a table is added. They're
+// written after the rvus have been committed, each in its own transaction, so they
+// aren't atomic with the release. If one fails the rvus stay loaded but the load is
+// logged as failed, so the next Load loads the release again. The ZIP code to carrier
+// locality archives are published separately, they're loaded into ZIPLocalityTable if
+// it's set.
+type Loader struct {
+	DB                  *sqlx.DB
+	Schema              string
+	Table               string
+	LoadLog             string
+	Mode                LoadMode
+	Partitioned         bool
+	Force               bool
+	Sinks               []Sink
+	GPCITable           string
+	LocalityCountyTable string
+	AnesthesiaCFTable   string
+	OPPSCapTable        string
+	ZIPLocalityTable    string
+}
+
+// referenceRows is the collection type of a reference file, ie GPCIs
+type referenceRows interface {
+	CreateTable(ctx context.Context, db *sqlx.DB, schema, table string) (sql.Result, error)
+	Put(ctx context.Context, db *sqlx.DB, schema, table string) (int64, error)
+}
+
+// referenceFile is a reference file the loader writes to table
+type referenceFile struct {
+	name    string
+	table   string
+	rows    referenceRows // empty, for CreateTable
+	fromZip func(data []byte, md ReleaseMetadata) (referenceRows, error)
+}
+
+// referenceFiles returns the reference files whose table is set
+func (l Loader) referenceFiles() []referenceFile {
+	all := []referenceFile{
+		{"gpci", l.GPCITable, GPCIs{}, func(data []byte, md ReleaseMetadata) (referenceRows, error) {
+			return GPCIsFromZip(data, md)
+		}},
+		{"locco", l.LocalityCountyTable, LocalityCounties{}, func(data []byte, md ReleaseMetadata) (referenceRows, error) {
+			return LocalityCountiesFromZip(data, md)
+		}},
+		{"anes", l.AnesthesiaCFTable, AnesthesiaCFs{}, func(data []byte, md ReleaseMetadata) (referenceRows, error) {
+			return AnesthesiaCFsFromZip(data, md)
+		}},
+		{"oppscap", l.OPPSCapTable, OPPSCaps{}, func(data []byte, md ReleaseMetadata) (referenceRows, error) {
+			return OPPSCapsFromZip(data, md)
+		}},
+	}
+	files := []referenceFile{}
+	for _, f := range all {
+		if f.table != "" {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// Setup creates the schema and every table the loader writes to
+func (l Loader) Setup(ctx context.Context) error {
+	sink, _ := l.dbSink()
+	if err := append(MultiSink{sink}, l.Sinks...).CreateSchema(ctx); err != nil {
+		return err
+	}
+	for _, f := range l.referenceFiles() {
+		if _, err := f.rows.CreateTable(ctx, l.DB, l.Schema, f.table); err != nil {
+			return fmt.Errorf("%s: %w", f.name, err)
+		}
+	}
+	if l.ZIPLocalityTable != "" {
+		if _, err := (ZIPLocalities{}).CreateTable(ctx, l.DB, l.Schema, l.ZIPLocalityTable); err != nil {
+			return fmt.Errorf("zip: %w", err)
+		}
+	}
+	if l.sqlite() {
+		_, err := CreateSQLiteLoadLogTable(ctx, l.DB, l.LoadLog)
+		return err
+	}
+	_, err := CreatePostgresLoadLogTable(ctx, l.DB, l.Schema, l.LoadLog)
+	return err
+}
+
+// loadReferenceFiles loads the reference files whose tables are set from the archive,
+// only those that haven't been loaded from it yet if missingOnly is set
+func (l Loader) loadReferenceFiles(ctx context.Context, data []byte, md ReleaseMetadata, missingOnly bool) error {
+	for _, f := range l.referenceFiles() {
+		if missingOnly {
+			names, err := dbTableNames(l.DB, l.Schema, f.table)
+			if err != nil {
+				return err
+			}
+			loaded, err := referenceRowsExist(ctx, l.DB, names, md.EffectiveDate, md.Source)
+			if err != nil {
+				return fmt.Errorf("%s: %w", f.name, err)
+			}
+			if loaded {
+				continue
+			}
+		}
+		rows, err := f.fromZip(data, md)
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.name, err)
+		}
+		if _, err := rows.Put(ctx, l.DB, l.Schema, f.table); err != nil {
+			return fmt.Errorf("%s: %w", f.name, err)
+		}
+	}
+	return nil
+}
+
+// dbSink returns the sink for DB along with a function reporting the rows it inserted
+func (l Loader) dbSink() (Sink, func() int64) {
+	if l.sqlite() {
+		s := &SQLiteSink{DB: l.DB, Table: l.Table}
+		return s, func() int64 { return s.Inserted }
+	}
+	p := &PostgresSink{DB: l.DB, Schema: l.Schema, Table: l.Table, Mode: l.Mode, Partitioned: l.Partitioned}
+	return p, func() int64 { return p.Inserted }
+}
+
+// Load loads every release in cfg.Data in order, then cfg.ZIPData if ZIPLocalityTable
+// is set. A failed release is logged and doesn't stop the rest from loading, the
+// errors are joined and returned at the end.
+func (l Loader) Load(ctx context.Context, cfg Config) error {
+	errs := []error{}
+	for _, dc := range cfg.Data {
+		pattern := dc.FileRegex
+		if pattern == "" {
+			pattern = cfg.RVUFileRegex
+		}
+		if pattern == "" {
+			pattern = DefaultRVUFileRegex
+		}
+		ll, err := l.LoadRelease(ctx, dc.URL, pattern, dc.EffectiveDate)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", dc.URL, err))
+		}
+		logLoad(dc, ll)
+	}
+	for _, dc := range cfg.ZIPData {
+		if l.ZIPLocalityTable == "" {
+			break
+		}
+		ll, err := l.LoadZIPRelease(ctx, dc.URL, dc.EffectiveDate)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", dc.URL, err))
+		}
+		logLoad(dc, ll)
+	}
+	return errors.Join(errs...)
+}
+
+func logLoad(dc DataConfig, ll LoadLog) {
+	log.Printf("%s %s: %s - parsed: %d, inserted: %d, rejected: %d",
+		dc.EffectiveDate.Time.Format(time.DateOnly), dc.URL, ll.Status,
+		ll.RowsParsed, ll.RowsInserted, ll.RowsRejected,
+	)
+}
+
+// LoadRelease loads a single release and records the attempt in load_log
+func (l Loader) LoadRelease(ctx context.Context, srcUrl, pattern string, effectiveDate pgtype.Date) (LoadLog, error) {
+	return l.logAttempt(ctx, srcUrl, effectiveDate, func(ll *LoadLog) error {
+		return l.loadRelease(ctx, ll, pattern)
+	})
+}
+
+// LoadZIPRelease loads a ZIP code to carrier locality archive into ZIPLocalityTable
+// and records the attempt in load_log
+func (l Loader) LoadZIPRelease(ctx context.Context, srcUrl string, effectiveDate pgtype.Date) (LoadLog, error) {
+	if l.ZIPLocalityTable == "" {
+		return LoadLog{}, errors.New("no zip locality table")
+	}
+	return l.logAttempt(ctx, srcUrl, effectiveDate, func(ll *LoadLog) error {
+		return l.loadZIPRelease(ctx, ll)
+	})
+}
+
+// logAttempt runs load, recording the attempt and its outcome in load_log
+func (l Loader) logAttempt(ctx context.Context, srcUrl string, effectiveDate pgtype.Date, load func(*LoadLog) error) (LoadLog, error) {
+	ll := LoadLog{
+		Source:        srcUrl,
+		EffectiveDate: effectiveDate,
+		StartTime:     time.Now().UTC(),
+		Status:        LoadStatusRunning,
+	}
+	q := `
+	insert into %s (source, effective_date, start_time, status)
+	values (?, ?, ?, ?)
+	returning load_id`
+	err := l.DB.QueryRowContext(ctx, l.DB.Rebind(fmt.Sprintf(q, l.loadLogTable())),
+		ll.Source, ll.EffectiveDate.Time.Format(time.DateOnly), ll.StartTime, ll.Status,
+	).Scan(&ll.LoadID)
+	if err != nil {
+		return ll, err
+	}
+
+	err = load(&ll)
+	switch {
+	case err != nil:
+		ll.Status = LoadStatusFailed
+		ll.Error = sql.NullString{String: err.Error(), Valid: true}
+	case ll.Status == LoadStatusRunning:
+		ll.Status = LoadStatusSuccess
+	}
+	ll.EndTime = sql.NullTime{Time: time.Now().UTC(), Valid: true}
+
+	q = `
+	update %s set
+		checksum = :checksum,
+		rows_parsed = :rows_parsed,
+		rows_inserted = :rows_inserted,
+		rows_rejected = :rows_rejected,
+		end_time = :end_time,
+		status = :status,
+		error = :error
+	where load_id = :load_id`
+	if _, uerr := l.DB.NamedExecContext(ctx, fmt.Sprintf(q, l.loadLogTable()), ll); uerr != nil {
+		return ll, errors.Join(err, uerr)
+	}
+	return ll, err
+}
+
+func (l Loader) loadRelease(ctx context.Context, ll *LoadLog, pattern string) error {
+	spec := ReleaseSpec{URL: ll.Source, EffectiveDate: ll.EffectiveDate, FileRegex: pattern}
+	zippedData, md, err := fetchArchive(ctx, spec, newFetchOptions(spec, nil))
+	if err != nil {
+		return err
+	}
+	ll.Checksum = sql.NullString{String: md.Checksum, Valid: true}
+
+	skip, err := l.skip(ctx, ll)
+	if err != nil {
+		return err
+	}
+	if skip {
+		// the rvus are up to date, but a reference table may have been added since
+		return l.loadReferenceFiles(ctx, zippedData, md, true)
+	}
+
+	records, err := CSVFromZip(zippedData, pattern)
+	if err != nil {
+		return err
+	}
+	if !ll.EffectiveDate.Valid {
+		return errors.New("valid effectiveDate required")
+	}
+	// blank lines and footnotes have no status code, they're neither parsed nor rejected
+	rvus := RelativeValueUnits{}
+	variants := variantCounter{}
+	var rejected []error
+	for _, r := range records {
+		rvu, ok, err := rvuFromRecord(r, md.Source, md.ExtractTime, md.LastModified, ll.EffectiveDate, variants)
+		switch {
+		case err != nil:
+			rejected = append(rejected, fmt.Errorf("%s %s: %w", rvu.HCPCS, rvu.ModifierCode.String, err))
+		case ok:
+			rvus = append(rvus, rvu)
+		}
+	}
+	ll.RowsParsed = int64(len(rvus) + len(rejected))
+	ll.RowsRejected = int64(len(rejected))
+	if len(rejected) > 0 {
+		return fmt.Errorf("%d of %d rows rejected, the first: %w", len(rejected), ll.RowsParsed, rejected[0])
+	}
+
+	if l.sqlite() && l.Mode == LoadModeUpsert {
+		return fmt.Errorf("%s load mode is not supported for sqlite", l.Mode)
+	}
+	sink, inserted := l.dbSink()
+	release := DataConfig{EffectiveDate: ll.EffectiveDate, URL: ll.Source, FileRegex: pattern}
+	if err := WriteRelease(ctx, append(MultiSink{sink}, l.Sinks...), release, rvus); err != nil {
+		return err
+	}
+	ll.RowsInserted = inserted()
+	return l.loadReferenceFiles(ctx, zippedData, md, false)
+}
+
+func (l Loader) loadZIPRelease(ctx context.Context, ll *LoadLog) error {
+	spec := ReleaseSpec{URL: ll.Source, EffectiveDate: ll.EffectiveDate}
+	zippedData, md, err := fetchArchive(ctx, spec, newFetchOptions(spec, nil))
+	if err != nil {
+		return err
+	}
+	ll.Checksum = sql.NullString{String: md.Checksum, Valid: true}
+
+	if skip, err := l.skip(ctx, ll); skip || err != nil {
+		return err
+	}
+
+	zls, err := ZIPLocalitiesFromZip(zippedData, md)
+	if err != nil {
+		return err
+	}
+	ll.RowsParsed = int64(len(zls))
+	ll.RowsInserted, err = zls.Put(ctx, l.DB, l.Schema, l.ZIPLocalityTable)
+	return err
+}
+
+// skip marks ll skipped if the release was already loaded and Force isn't set
+func (l Loader) skip(ctx context.Context, ll *LoadLog) (bool, error) {
+	if l.Force {
+		return false, nil
+	}
+	loaded, err := l.alreadyLoaded(ctx, *ll)
+	if loaded {
+		ll.Status = LoadStatusSkipped
+	}
+	return loaded, err
+}
+
+// alreadyLoaded reports whether the most recent completed load of the release
+// succeeded with the same archive checksum
+func (l Loader) alreadyLoaded(ctx context.Context, ll LoadLog) (bool, error) {
+	q := `
+	select status, checksum from %s
+	where source = ?
+	and effective_date = ?
+	and load_id <> ?
+	and status not in (?, ?)
+	order by start_time desc
+	limit 1`
+	var status LoadStatus
+	var checksum sql.NullString
+	err := l.DB.QueryRowContext(ctx, l.DB.Rebind(fmt.Sprintf(q, l.loadLogTable())),
+		ll.Source, ll.EffectiveDate.Time.Format(time.DateOnly), ll.LoadID, LoadStatusRunning, LoadStatusSkipped,
+	).Scan(&status, &checksum)
+	switch {
+	case err == sql.ErrNoRows:
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+	return status == LoadStatusSuccess && checksum == ll.Checksum, nil
+}
+
+func (l Loader) sqlite() bool {
+	return l.DB.DriverName() == DriverSQLite
+}
+
+func (l Loader) loadLogTable() string {
+	schema := l.Schema
+	switch {
+	case l.sqlite():
+		schema = ""
+	case schema == "":
+		schema = "cmsrvu"
+	}
+	// Setup has already validated the name
+	name, _ := loadLogName(schema, l.LoadLog)
+	return name
+}
+
+// loadLogName returns the quoted name of the load log table, unqualified if schema is
+// empty (sqlite). table defaults to load_log.
+func loadLogName(schema, table string) (string, error) {
+	if table == "" {
+		table = "load_log"
+	}
+	if err := ValidateIdentifier(table); err != nil {
+		return "", err
+	}
+	if schema == "" {
+		return QuoteIdentifier(table), nil
+	}
+	if err := ValidateIdentifier(schema); err != nil {
+		return "", err
+	}
+	return QuoteIdentifier(schema) + "." + QuoteIdentifier(table), nil
+}
@@ -0,0 +1,214 @@
+package cmsrvu
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jmoiron/sqlx"
+)
+
+// DefaultLOCCOFileRegex matches the locality/county crosswalk in an rvu archive, ie
+// 24LOCCO.csv
+var DefaultLOCCOFileRegex = `(?i)^\d*locco.*\.csv$`
+
+// LocalityCounty is a county from CMS's locality/county crosswalk (LOCCO) file, along
+// with the contractor and locality that price claims from it. The file lists every
+// county of a locality in one cell, they're split into a row each. Localities that
+// cover a whole state or the rest of one have a single row with County set to ALL
+// COUNTIES or ALL OTHER COUNTIES, as in the file.
+type LocalityCounty struct {
+	Source          string      `db:"_source"`
+	ExtractTime     time.Time   `db:"_extract_time"`
+	LastModified    time.Time   `db:"_last_modified"`
+	EffectiveDate   pgtype.Date `db:"_effective_date"`
+	MAC             string      `db:"mac"`
+	LocalityNumber  string      `db:"locality_number"` // two digits, ie 01
+	State           string      `db:"state"`           // the state's name, upper case
+	FeeScheduleArea string      `db:"fee_schedule_area"`
+	County          string      `db:"county"` // upper case, see normalizeCounty
+}
+
+type LocalityCounties []LocalityCounty
+
+var localityCountyColumns = []string{
+	"_source",
+	"_extract_time",
+	"_last_modified",
+	"_effective_date",
+	"mac",
+	"locality_number",
+	"state",
+	"fee_schedule_area",
+	"county",
+}
+
+var localityCountyKey = []string{"_effective_date", "mac", "locality_number", "state", "county"}
+
+// loccoHeader matches the LOCCO file's header, the title above it mentions counties
+// and localities too but not contractors
+func loccoHeader(record []string) bool {
+	return columnIndex(record, containsAll("CONTRACTOR")) >= 0 &&
+		columnIndex(record, containsAll("LOCALITY")) >= 0 &&
+		columnIndex(record, containsAll("COUNT")) >= 0
+}
+
+// FetchLocalityCounties downloads the release described by spec and parses its LOCCO
+// file, use WithFileRegex to override DefaultLOCCOFileRegex
+func FetchLocalityCounties(ctx context.Context, spec ReleaseSpec, opts ...Option) (LocalityCounties, ReleaseMetadata, error) {
+	header, records, md, err := fetchCSV(ctx, spec, opts, DefaultLOCCOFileRegex, loccoHeader)
+	if err != nil {
+		return nil, md, err
+	}
+	lcs, err := LocalityCountiesFromRecords(header, records, md)
+	return lcs, md, err
+}
+
+// LocalityCountiesFromZip parses the LOCCO file from an archive that's already been
+// downloaded
+func LocalityCountiesFromZip(data []byte, md ReleaseMetadata) (LocalityCounties, error) {
+	header, records, err := CSVFromZipHeader(data, DefaultLOCCOFileRegex, loccoHeader)
+	if err != nil {
+		return nil, err
+	}
+	return LocalityCountiesFromRecords(header, records, md)
+}
+
+// LocalityCountiesFromRecords converts the header and records of a LOCCO file (see
+// CSVFromZipHeader) to a row per county. The state is only given on the first
+// locality of each state, so it's carried forward, and rows without a contractor
+// number (blank lines between states, footnotes) are skipped.
+func LocalityCountiesFromRecords(header []string, records [][]string, md ReleaseMetadata) (LocalityCounties, error) {
+	if !md.EffectiveDate.Valid {
+		return nil, errors.New("valid effectiveDate required")
+	}
+	cols := map[string]int{
+		"mac":               columnIndex(header, containsAll("CONTRACTOR")),
+		"locality number":   columnIndex(header, containsAll("LOCALITY")),
+		"state":             columnIndex(header, func(c string) bool { return c == "STATE" }),
+		"fee schedule area": columnIndex(header, containsAll("AREA")),
+		"counties":          columnIndex(header, containsAll("COUNT")),
+	}
+	for name, i := range cols {
+		if i < 0 {
+			return nil, fmt.Errorf("locco file has no %s column", name)
+		}
+	}
+
+	lcs := LocalityCounties{}
+	state := ""
+	for _, r := range records {
+		if s := strings.ToUpper(cleanString(r[cols["state"]])); s != "" {
+			state = s
+		}
+		mac := cleanString(r[cols["mac"]])
+		if !isDigits(mac) {
+			continue
+		}
+		if state == "" {
+			return nil, fmt.Errorf("locco file has no state for contractor %s", mac)
+		}
+		for _, county := range splitCounties(r[cols["counties"]]) {
+			lcs = append(lcs, LocalityCounty{
+				Source:          md.Source,
+				ExtractTime:     md.ExtractTime,
+				LastModified:    md.LastModified,
+				EffectiveDate:   md.EffectiveDate,
+				MAC:             mac,
+				LocalityNumber:  localityNumber(r[cols["locality number"]]),
+				State:           state,
+				FeeScheduleArea: strings.Trim(strings.ToUpper(cleanString(r[cols["fee schedule area"]])), "* "),
+				County:          county,
+			})
+		}
+	}
+	return lcs, nil
+}
+
+// countySeparator splits the counties cell, ie "BRONX, KINGS, NEW YORK AND QUEENS" or
+// "BALTIMORE CITY/BALTIMORE". None of the counties in a locality that isn't statewide
+// have "and" in their name.
+var countySeparator = regexp.MustCompile(`\s*(?:,|/|\bAND\b)\s*`)
+
+// splitCounties returns the normalized counties in a LOCCO counties cell
+func splitCounties(cell string) []string {
+	counties := []string{}
+	for _, c := range countySeparator.Split(strings.ToUpper(cleanString(cell)), -1) {
+		if c = normalizeCounty(c); c != "" {
+			counties = append(counties, c)
+		}
+	}
+	return counties
+}
+
+// normalizeCounty upper cases county and drops a trailing COUNTY or PARISH, so
+// "Los Angeles County" matches the file's LOS ANGELES
+func normalizeCounty(county string) string {
+	county = strings.ToUpper(cleanString(county))
+	county = strings.TrimSpace(strings.TrimSuffix(county, " COUNTY"))
+	county = strings.TrimSpace(strings.TrimSuffix(county, " PARISH"))
+	return strings.Trim(county, "* ")
+}
+
+// CreateTable creates schema.table for LocalityCounties in postgres or sqlite (schema
+// is ignored)
+func (lcs LocalityCounties) CreateTable(ctx context.Context, db *sqlx.DB, schema, table string) (sql.Result, error) {
+	names, err := dbTableNames(db, schema, table)
+	if err != nil {
+		return nil, err
+	}
+	q := `
+	create table if not exists %[1]s (
+		_source text,
+		_extract_time %[2]s,
+		_last_modified %[2]s,
+		_effective_date date not null,
+		mac text not null,
+		locality_number text not null,
+		state text not null,
+		fee_schedule_area text,
+		county text not null,
+		primary key (_effective_date, mac, locality_number, state, county)
+	)`
+	return createReferenceTable(ctx, db, names, q)
+}
+
+// Put writes lcs to schema.table, replacing any county already loaded for the same
+// release and locality. It returns the number of rows written.
+func (lcs LocalityCounties) Put(ctx context.Context, db *sqlx.DB, schema, table string) (int64, error) {
+	names, err := dbTableNames(db, schema, table)
+	if err != nil {
+		return 0, err
+	}
+	return putReferenceRows(ctx, db, names, localityCountyColumns, localityCountyKey, lcs)
+}
+
+// QueryLocalityForCounty returns the crosswalk row that prices claims from county in
+// state on dateOfService, from the latest release effective on or before it in
+// schema.table. state is the state's name as in the LOCCO file, ie CALIFORNIA. Counties
+// that aren't listed fall back to their state's ALL COUNTIES or ALL OTHER COUNTIES
+// row. It returns sql.ErrNoRows if there's neither.
+func QueryLocalityForCounty(ctx context.Context, db *sqlx.DB, schema, table, state, county string, dateOfService pgtype.Date) (LocalityCounty, error) {
+	lc := LocalityCounty{}
+	names, err := dbTableNames(db, schema, table)
+	if err != nil {
+		return lc, err
+	}
+	q := `
+	select %[2]s from %[1]s
+	where _effective_date = (select max(_effective_date) from %[1]s where _effective_date <= ?)
+	and state = ?
+	and (county = ? or county like 'ALL %%')
+	order by case when county = ? then 0 else 1 end
+	limit 1`
+	q = fmt.Sprintf(q, names.qualified(""), strings.Join(localityCountyColumns, ", "))
+	state = strings.ToUpper(cleanString(state))
+	county = normalizeCounty(county)
+	err = db.GetContext(ctx, &lc, db.Rebind(q), dateOfService.Time.Format(time.DateOnly), state, county, county)
+	return lc, err
+}
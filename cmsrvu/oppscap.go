@@ -0,0 +1,244 @@
+package cmsrvu
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jmoiron/sqlx"
+)
+
+// DefaultOPPSCAPFileRegex matches the OPPS payment cap file in an rvu archive, ie
+// OPPSCAP_JUL.csv
+var DefaultOPPSCAPFileRegex = `(?i)^oppscap.*\.csv$`
+
+// OPPSCap is a line from CMS's OPPSCAP file - the OPPS payment cap of an imaging
+// service in a locality. The technical component of imaging services is paid the lesser
+// of the fee schedule amount and the cap, see Pricer.Price.
+type OPPSCap struct {
+	Source           string      `db:"_source"`
+	ExtractTime      time.Time   `db:"_extract_time"`
+	LastModified     time.Time   `db:"_last_modified"`
+	EffectiveDate    pgtype.Date `db:"_effective_date"`
+	HCPCS            string      `db:"hcpcs"`
+	Modifier         string      `db:"modifier"` // "" for no modifier
+	StatusCode       string      `db:"status_code"`
+	MAC              string      `db:"mac"`
+	LocalityNumber   string      `db:"locality_number"` // two digits, ie 01
+	FacilityPrice    Decimal     `db:"facility_price"`
+	NonFacilityPrice Decimal     `db:"nonfacility_price"`
+}
+
+type OPPSCaps []OPPSCap
+
+var oppsCapColumns = []string{
+	"_source",
+	"_extract_time",
+	"_last_modified",
+	"_effective_date",
+	"hcpcs",
+	"modifier",
+	"status_code",
+	"mac",
+	"locality_number",
+	"facility_price",
+	"nonfacility_price",
+}
+
+var oppsCapKey = []string{"_effective_date", "hcpcs", "modifier", "mac", "locality_number"}
+
+// Locality returns the locality of c
+func (c OPPSCap) Locality() Locality { return Locality{c.MAC, c.LocalityNumber} }
+
+// oppsCapFacility and oppsCapNonFacility match the OPPSCAP file's price columns, the
+// non-facility one is spelled NON-FACILTY in some years
+func oppsCapFacility(c string) bool {
+	return strings.Contains(c, "FACILITY") && !strings.Contains(c, "NON")
+}
+
+func oppsCapNonFacility(c string) bool {
+	return strings.Contains(c, "NON") && strings.Contains(c, "FAC")
+}
+
+// oppsCapHeader matches the OPPSCAP file's header
+func oppsCapHeader(record []string) bool {
+	return rvuHeader(record) &&
+		columnIndex(record, anesLocality) >= 0 &&
+		columnIndex(record, oppsCapNonFacility) >= 0
+}
+
+// FetchOPPSCaps downloads the release described by spec and parses its OPPSCAP file,
+// use WithFileRegex to override DefaultOPPSCAPFileRegex
+func FetchOPPSCaps(ctx context.Context, spec ReleaseSpec, opts ...Option) (OPPSCaps, ReleaseMetadata, error) {
+	header, records, md, err := fetchCSV(ctx, spec, opts, DefaultOPPSCAPFileRegex, oppsCapHeader)
+	if err != nil {
+		return nil, md, err
+	}
+	caps, err := OPPSCapsFromRecords(header, records, md)
+	return caps, md, err
+}
+
+// OPPSCapsFromZip parses the OPPSCAP file from an archive that's already been
+// downloaded
+func OPPSCapsFromZip(data []byte, md ReleaseMetadata) (OPPSCaps, error) {
+	header, records, err := CSVFromZipHeader(data, DefaultOPPSCAPFileRegex, oppsCapHeader)
+	if err != nil {
+		return nil, err
+	}
+	return OPPSCapsFromRecords(header, records, md)
+}
+
+// OPPSCapsFromRecords converts the header and records of an OPPSCAP file (see
+// CSVFromZipHeader) to OPPSCaps. Rows without a contractor number (footnotes, etc) are
+// skipped, blank prices are null.
+func OPPSCapsFromRecords(header []string, records [][]string, md ReleaseMetadata) (OPPSCaps, error) {
+	if !md.EffectiveDate.Valid {
+		return nil, errors.New("valid effectiveDate required")
+	}
+	cols := map[string]int{
+		"hcpcs":             columnIndex(header, func(c string) bool { return strings.HasPrefix(c, "HCPCS") }),
+		"modifier":          columnIndex(header, func(c string) bool { return strings.HasPrefix(c, "MOD") }),
+		"status":            columnIndex(header, containsAll("STAT")),
+		"contractor":        columnIndex(header, anesContractor),
+		"locality number":   columnIndex(header, anesLocality),
+		"facility price":    columnIndex(header, oppsCapFacility),
+		"nonfacility price": columnIndex(header, oppsCapNonFacility),
+	}
+	for name, i := range cols {
+		if i < 0 {
+			return nil, fmt.Errorf("oppscap file has no %s column", name)
+		}
+	}
+
+	caps := OPPSCaps{}
+	for _, r := range records {
+		mac := cleanString(r[cols["contractor"]])
+		if !isDigits(mac) {
+			continue
+		}
+		c := OPPSCap{
+			Source:         md.Source,
+			ExtractTime:    md.ExtractTime,
+			LastModified:   md.LastModified,
+			EffectiveDate:  md.EffectiveDate,
+			HCPCS:          strings.ToUpper(cleanString(r[cols["hcpcs"]])),
+			Modifier:       strings.ToUpper(cleanString(r[cols["modifier"]])),
+			StatusCode:     strings.ToUpper(cleanString(r[cols["status"]])),
+			MAC:            mac,
+			LocalityNumber: localityNumber(r[cols["locality number"]]),
+		}
+		var err error
+		if c.FacilityPrice, err = ParseDecimal(r[cols["facility price"]]); err != nil {
+			return nil, fmt.Errorf("%s %s: %w", c.HCPCS, c.Modifier, err)
+		}
+		if c.NonFacilityPrice, err = ParseDecimal(r[cols["nonfacility price"]]); err != nil {
+			return nil, fmt.Errorf("%s %s: %w", c.HCPCS, c.Modifier, err)
+		}
+		caps = append(caps, c)
+	}
+	return caps, nil
+}
+
+// CreateTable creates schema.table for OPPSCaps in postgres or sqlite (schema is
+// ignored)
+func (caps OPPSCaps) CreateTable(ctx context.Context, db *sqlx.DB, schema, table string) (sql.Result, error) {
+	names, err := dbTableNames(db, schema, table)
+	if err != nil {
+		return nil, err
+	}
+	q := `
+	create table if not exists %[1]s (
+		_source text,
+		_extract_time %[2]s,
+		_last_modified %[2]s,
+		_effective_date date not null,
+		hcpcs text not null,
+		modifier text not null,
+		status_code text,
+		mac text not null,
+		locality_number text not null,
+		facility_price %[3]s,
+		nonfacility_price %[3]s,
+		primary key (_effective_date, hcpcs, modifier, mac, locality_number)
+	)`
+	return createReferenceTable(ctx, db, names, q)
+}
+
+// Put writes caps to schema.table, replacing the cap of any code and locality already
+// loaded for the same release. It returns the number of rows written.
+func (caps OPPSCaps) Put(ctx context.Context, db *sqlx.DB, schema, table string) (int64, error) {
+	names, err := dbTableNames(db, schema, table)
+	if err != nil {
+		return 0, err
+	}
+	return putReferenceRows(ctx, db, names, oppsCapColumns, oppsCapKey, caps)
+}
+
+// QueryOPPSCaps reads the caps in effect on dateOfService from schema.table, or every
+// release if dateOfService isn't valid, see QueryGPCIs. If any hcpcs are given only
+// their caps are read.
+func QueryOPPSCaps(ctx context.Context, db *sqlx.DB, schema, table string, dateOfService pgtype.Date, hcpcs ...string) (OPPSCaps, error) {
+	names, err := dbTableNames(db, schema, table)
+	if err != nil {
+		return nil, err
+	}
+	q, args := queryInEffect(names, oppsCapColumns, dateOfService)
+	if len(hcpcs) > 0 {
+		where := "\nwhere"
+		if dateOfService.Valid {
+			where = "\nand"
+		}
+		q, args, err = sqlx.In(q+where+" hcpcs in (?)", append(args, hcpcs)...)
+		if err != nil {
+			return nil, err
+		}
+	}
+	caps := OPPSCaps{}
+	if err := db.SelectContext(ctx, &caps, db.Rebind(q+"\norder by _effective_date, hcpcs, modifier, mac, locality_number"), args...); err != nil {
+		return nil, err
+	}
+	return caps, nil
+}
+
+// OPPSCapIndex looks up the OPPS cap of a code in a locality on a date of service from
+// memory, see GPCIIndex
+type OPPSCapIndex struct {
+	index localityIndex[OPPSCap]
+}
+
+// NewOPPSCapIndex indexes caps, which can span any number of releases
+func NewOPPSCapIndex(caps OPPSCaps) *OPPSCapIndex {
+	return &OPPSCapIndex{newLocalityIndex(caps, func(c OPPSCap) (pgtype.Date, Locality, rvuKey) {
+		return c.EffectiveDate, c.Locality(), rvuKey{c.HCPCS, c.Modifier}
+	})}
+}
+
+// Lookup returns the cap of hcpcs and modifier (as in the rvu file, "" for none) in
+// locality in the release in effect on dateOfService. ok is false if there's no such
+// release or the code isn't capped in the locality.
+func (x *OPPSCapIndex) Lookup(hcpcs, modifier string, locality Locality, dateOfService time.Time) (OPPSCap, bool) {
+	return x.index.lookup(locality, rvuKey{hcpcs, modifier}, dateOfService)
+}
+
+// OPPSCapApplies reports whether r's payment is capped at the OPPS amount, which is
+// when its CalculationFlag is set. The cap itself comes from the OPPSCAP file.
+func (r RelativeValueUnit) OPPSCapApplies() bool {
+	return r.CalculationFlag.Valid && r.CalculationFlag.Int64 != 0
+}
+
+// capAt returns the lesser of amount and cap, compared to the cent like amount, and
+// whether cap was strictly less. Null amounts stay null, and null caps don't apply.
+func capAt(amount, cap Decimal) (Decimal, bool) {
+	if !amount.Valid || !cap.Valid {
+		return amount, false
+	}
+	cap = cap.Round(2)
+	if amount.Cmp(cap) <= 0 {
+		return amount, false
+	}
+	return cap, true
+}
@@ -0,0 +1,44 @@
+package cmsrvu
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestCapAt(t *testing.T) {
+	tests := []struct {
+		amount, cap string
+		want        string
+		capped      bool
+	}{
+		{"59.78", "55.125", "55.13", true}, // the cap is rounded to the cent
+		{"59.78", "55.12", "55.12", true},
+		{"55.13", "55.125", "55.13", false}, // the same to the cent
+		{"59.78", "59.78", "59.78", false},
+		{"59.78", "90.00", "59.78", false},
+		{"", "55.12", "", false},      // NA stays NA
+		{"59.78", "", "59.78", false}, // no cap for the setting
+	}
+	for _, tt := range tests {
+		got, capped := capAt(mustDecimal(t, tt.amount), mustDecimal(t, tt.cap))
+		if got.String() != tt.want || capped != tt.capped {
+			t.Errorf("capAt(%q, %q) = %q, %t, want %q, %t", tt.amount, tt.cap, got, capped, tt.want, tt.capped)
+		}
+	}
+}
+
+func TestOPPSCapApplies(t *testing.T) {
+	tests := []struct {
+		flag sql.NullInt64
+		want bool
+	}{
+		{sql.NullInt64{}, false},
+		{sql.NullInt64{Int64: 0, Valid: true}, false},
+		{sql.NullInt64{Int64: 1, Valid: true}, true},
+	}
+	for _, tt := range tests {
+		if got := (RelativeValueUnit{CalculationFlag: tt.flag}).OPPSCapApplies(); got != tt.want {
+			t.Errorf("OPPSCapApplies with calculation flag %v = %t, want %t", tt.flag, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,356 @@
+package cmsrvu
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress/zstd"
+)
+
+// parquetRVU is the parquet layout of a RelativeValueUnit. Column names match the db
+// columns, sql.Null* fields become optional columns and the effective date uses the
+// DATE logical type. Decimals are optional strings with the same digits as
+// Decimal.String, files written when they were doubles read back the same way.
+type parquetRVU struct {
+	IDHash                                         string   `parquet:"_id_hash"`
+	NaturalKey                                     string   `parquet:"_natural_key"`
+	Variant                                        int64    `parquet:"_variant"`
+	ContentHash                                    string   `parquet:"_content_hash"`
+	HashVersion                                    string   `parquet:"_hash_version,dict"`
+	Source                                         string   `parquet:"_source,dict"`
+	ExtractTime                                    int64    `parquet:"_extract_time,timestamp(microsecond)"`
+	LastModified                                   int64    `parquet:"_last_modified,timestamp(microsecond)"`
+	EffectiveDate                                  int32    `parquet:"_effective_date,date"`
+	HCPCS                                          string   `parquet:"hcpcs"`
+	ModifierCode                                   *string  `parquet:"modifier_code,optional,dict"`
+	Modifier                                       *string  `parquet:"modifier,optional,dict"`
+	Description                                    *string  `parquet:"description,optional"`
+	StatusCode                                     *string  `parquet:"status_code,optional,dict"`
+	Status                                         *string  `parquet:"status,optional,dict"`
+	WRVU                                           *string  `parquet:"wrvu,optional"`
+	NonFacilityPERVU                               *string  `parquet:"nonfacility_pervu,optional"`
+	NonFacilityNAIndicator                         bool     `parquet:"nonfacility_na_indicator"`
+	FacilityPERVU                                  *string  `parquet:"facility_pervu,optional"`
+	FacilityNAIndicator                            bool     `parquet:"facility_na_indicator"`
+	MalpracticeRVU                                 *string  `parquet:"malpractice_rvu,optional"`
+	TotalNonFacilityRVU                            *string  `parquet:"total_nonfacility_rvu,optional"`
+	TotalFacilityRVU                               *string  `parquet:"total_facility_rvu,optional"`
+	PCTCIndicator                                  *int64   `parquet:"pctc_indicator,optional"`
+	PCTC                                           *string  `parquet:"pctc,optional,dict"`
+	GlobalSurgeryCode                              *string  `parquet:"global_surgery_code,optional,dict"`
+	GlobalSurgery                                  *string  `parquet:"global_surgery,optional,dict"`
+	PreoperativePercentage                         *float64 `parquet:"preoperative_surgery,optional"`
+	IntraoperativePercentage                       *float64 `parquet:"intraoperative_surgery,optional"`
+	PostoperativePercentage                        *float64 `parquet:"postoperative_surgery,optional"`
+	MultipleProcedureCode                          *int64   `parquet:"multiple_procedure_code,optional"`
+	MultipleProcedure                              *string  `parquet:"multiple_procedure,optional,dict"`
+	BilateralSurgeryCode                           *int64   `parquet:"bilateral_surgery_code,optional"`
+	BilateralSurgery                               *string  `parquet:"bilateral_surgery,optional,dict"`
+	AssistantAtSurgeryCode                         *int64   `parquet:"assistant_at_surgery_code,optional"`
+	AssistantAtSurgery                             *string  `parquet:"assistant_at_surgery,optional,dict"`
+	CoSurgeonsCode                                 *int64   `parquet:"cosurgeons_code,optional"`
+	CoSurgeons                                     *string  `parquet:"cosurgeons,optional,dict"`
+	TeamSurgeryCode                                *int64   `parquet:"team_surgery_code,optional"`
+	TeamSurgery                                    *string  `parquet:"team_surgery,optional,dict"`
+	EndoscopicBaseCode                             *string  `parquet:"endoscopic_base_code,optional"`
+	ConversionFactor                               *string  `parquet:"conversion_factor,optional"`
+	PhysicianSupervisionOfDiagnosticProceduresCode *string  `parquet:"physician_supervision_of_diagnostic_procedures_code,optional,dict"`
+	PhysicianSupervisionOfDiagnosticProcedures     *string  `parquet:"physician_supervision_of_diagnostic_procedures,optional,dict"`
+	CalculationFlag                                *int64   `parquet:"calculation_flag,optional"`
+	DiagnosticImagingFamilyIndicator               *int64   `parquet:"diagnostic_imaging_family_indicator,optional"`
+	DiagnosticImagingFamily                        *string  `parquet:"diagnostic_imaging_family,optional,dict"`
+	NonFacilityPEUsedForOppsPaymentAmount          *string  `parquet:"nonfacility_pe_used_for_opps_payment_amount,optional"`
+	FacilityPEUsedForOppsPaymentAmount             *string  `parquet:"facility_pe_used_for_opps_payment_amount,optional"`
+	MalpracticeUsedForOppsPaymentAmount            *string  `parquet:"malpractice_used_for_opps_payment_amount,optional"`
+}
+
+func toParquetRVU(r RelativeValueUnit) parquetRVU {
+	return parquetRVU{
+		IDHash:                   r.IDHash,
+		NaturalKey:               r.NaturalKey,
+		Variant:                  r.Variant,
+		ContentHash:              r.ContentHash,
+		HashVersion:              r.HashVersion,
+		Source:                   r.Source,
+		ExtractTime:              r.ExtractTime.UnixMicro(),
+		LastModified:             r.LastModified.UnixMicro(),
+		EffectiveDate:            int32(r.EffectiveDate.Time.Unix() / 86400),
+		HCPCS:                    r.HCPCS,
+		ModifierCode:             nullStringPtr(r.ModifierCode),
+		Modifier:                 nullStringPtr(r.Modifier),
+		Description:              nullStringPtr(r.Description),
+		StatusCode:               nullStringPtr(r.StatusCode.NullString),
+		Status:                   nullStringPtr(r.Status),
+		WRVU:                     decimalStringPtr(r.WRVU),
+		NonFacilityPERVU:         decimalStringPtr(r.NonFacilityPERVU),
+		NonFacilityNAIndicator:   r.NonFacilityNAIndicator,
+		FacilityPERVU:            decimalStringPtr(r.FacilityPERVU),
+		FacilityNAIndicator:      r.FacilityNAIndicator,
+		MalpracticeRVU:           decimalStringPtr(r.MalpracticeRVU),
+		TotalNonFacilityRVU:      decimalStringPtr(r.TotalNonFacilityRVU),
+		TotalFacilityRVU:         decimalStringPtr(r.TotalFacilityRVU),
+		PCTCIndicator:            nullInt64Ptr(r.PCTCIndicator.NullInt64),
+		PCTC:                     nullStringPtr(r.PCTC),
+		GlobalSurgeryCode:        nullStringPtr(r.GlobalSurgeryCode.NullString),
+		GlobalSurgery:            nullStringPtr(r.GlobalSurgery),
+		PreoperativePercentage:   nullFloat64Ptr(r.PreoperativePercentage),
+		IntraoperativePercentage: nullFloat64Ptr(r.IntraoperativePercentage),
+		PostoperativePercentage:  nullFloat64Ptr(r.PostoperativePercentage),
+		MultipleProcedureCode:    nullInt64Ptr(r.MultipleProcedureCode.NullInt64),
+		MultipleProcedure:        nullStringPtr(r.MultipleProcedure),
+		BilateralSurgeryCode:     nullInt64Ptr(r.BilateralSurgeryCode.NullInt64),
+		BilateralSurgery:         nullStringPtr(r.BilateralSurgery),
+		AssistantAtSurgeryCode:   nullInt64Ptr(r.AssistantAtSurgeryCode.NullInt64),
+		AssistantAtSurgery:       nullStringPtr(r.AssistantAtSurgery),
+		CoSurgeonsCode:           nullInt64Ptr(r.CoSurgeonsCode.NullInt64),
+		CoSurgeons:               nullStringPtr(r.CoSurgeons),
+		TeamSurgeryCode:          nullInt64Ptr(r.TeamSurgeryCode.NullInt64),
+		TeamSurgery:              nullStringPtr(r.TeamSurgery),
+		EndoscopicBaseCode:       nullStringPtr(r.EndoscopicBaseCode),
+		ConversionFactor:         decimalStringPtr(r.ConversionFactor),
+		PhysicianSupervisionOfDiagnosticProceduresCode: nullStringPtr(r.PhysicianSupervisionOfDiagnosticProceduresCode.NullString),
+		PhysicianSupervisionOfDiagnosticProcedures:     nullStringPtr(r.PhysicianSupervisionOfDiagnosticProcedures),
+		CalculationFlag:                       nullInt64Ptr(r.CalculationFlag),
+		DiagnosticImagingFamilyIndicator:      nullInt64Ptr(r.DiagnosticImagingFamilyIndicator.NullInt64),
+		DiagnosticImagingFamily:               nullStringPtr(r.DiagnosticImagingFamily),
+		NonFacilityPEUsedForOppsPaymentAmount: decimalStringPtr(r.NonFacilityPEUsedForOppsPaymentAmount),
+		FacilityPEUsedForOppsPaymentAmount:    decimalStringPtr(r.FacilityPEUsedForOppsPaymentAmount),
+		MalpracticeUsedForOppsPaymentAmount:   decimalStringPtr(r.MalpracticeUsedForOppsPaymentAmount),
+	}
+}
+
+// fromParquetRVU is the inverse of toParquetRVU
+func fromParquetRVU(p parquetRVU) (RelativeValueUnit, error) {
+	var errs []error
+	dec := func(s *string) Decimal {
+		d, err := ptrDecimal(s)
+		errs = append(errs, err)
+		return d
+	}
+	r := RelativeValueUnit{
+		IDHash:                   p.IDHash,
+		NaturalKey:               p.NaturalKey,
+		Variant:                  p.Variant,
+		ContentHash:              p.ContentHash,
+		HashVersion:              p.HashVersion,
+		Source:                   p.Source,
+		ExtractTime:              time.UnixMicro(p.ExtractTime).UTC(),
+		LastModified:             time.UnixMicro(p.LastModified).UTC(),
+		EffectiveDate:            pgtype.Date{Time: time.Unix(int64(p.EffectiveDate)*86400, 0).UTC(), Valid: true},
+		HCPCS:                    p.HCPCS,
+		ModifierCode:             ptrNullString(p.ModifierCode),
+		Modifier:                 ptrNullString(p.Modifier),
+		Description:              ptrNullString(p.Description),
+		StatusCode:               StatusCode{ptrNullString(p.StatusCode)},
+		Status:                   ptrNullString(p.Status),
+		WRVU:                     dec(p.WRVU),
+		NonFacilityPERVU:         dec(p.NonFacilityPERVU),
+		NonFacilityNAIndicator:   p.NonFacilityNAIndicator,
+		FacilityPERVU:            dec(p.FacilityPERVU),
+		FacilityNAIndicator:      p.FacilityNAIndicator,
+		MalpracticeRVU:           dec(p.MalpracticeRVU),
+		TotalNonFacilityRVU:      dec(p.TotalNonFacilityRVU),
+		TotalFacilityRVU:         dec(p.TotalFacilityRVU),
+		PCTCIndicator:            PCTCIndicator{ptrNullInt64(p.PCTCIndicator)},
+		PCTC:                     ptrNullString(p.PCTC),
+		GlobalSurgeryCode:        GlobalPeriod{ptrNullString(p.GlobalSurgeryCode)},
+		GlobalSurgery:            ptrNullString(p.GlobalSurgery),
+		PreoperativePercentage:   ptrNullFloat64(p.PreoperativePercentage),
+		IntraoperativePercentage: ptrNullFloat64(p.IntraoperativePercentage),
+		PostoperativePercentage:  ptrNullFloat64(p.PostoperativePercentage),
+		MultipleProcedureCode:    MultipleProcedureIndicator{ptrNullInt64(p.MultipleProcedureCode)},
+		MultipleProcedure:        ptrNullString(p.MultipleProcedure),
+		BilateralSurgeryCode:     BilateralSurgeryIndicator{ptrNullInt64(p.BilateralSurgeryCode)},
+		BilateralSurgery:         ptrNullString(p.BilateralSurgery),
+		AssistantAtSurgeryCode:   AssistantAtSurgeryIndicator{ptrNullInt64(p.AssistantAtSurgeryCode)},
+		AssistantAtSurgery:       ptrNullString(p.AssistantAtSurgery),
+		CoSurgeonsCode:           CoSurgeonsIndicator{ptrNullInt64(p.CoSurgeonsCode)},
+		CoSurgeons:               ptrNullString(p.CoSurgeons),
+		TeamSurgeryCode:          TeamSurgeryIndicator{ptrNullInt64(p.TeamSurgeryCode)},
+		TeamSurgery:              ptrNullString(p.TeamSurgery),
+		EndoscopicBaseCode:       ptrNullString(p.EndoscopicBaseCode),
+		ConversionFactor:         dec(p.ConversionFactor),
+		PhysicianSupervisionOfDiagnosticProceduresCode: SupervisionCode{ptrNullString(p.PhysicianSupervisionOfDiagnosticProceduresCode)},
+		PhysicianSupervisionOfDiagnosticProcedures:     ptrNullString(p.PhysicianSupervisionOfDiagnosticProcedures),
+		CalculationFlag:                       ptrNullInt64(p.CalculationFlag),
+		DiagnosticImagingFamilyIndicator:      ImagingFamilyIndicator{ptrNullInt64(p.DiagnosticImagingFamilyIndicator)},
+		DiagnosticImagingFamily:               ptrNullString(p.DiagnosticImagingFamily),
+		NonFacilityPEUsedForOppsPaymentAmount: dec(p.NonFacilityPEUsedForOppsPaymentAmount),
+		FacilityPEUsedForOppsPaymentAmount:    dec(p.FacilityPEUsedForOppsPaymentAmount),
+		MalpracticeUsedForOppsPaymentAmount:   dec(p.MalpracticeUsedForOppsPaymentAmount),
+	}
+	return r, errors.Join(errs...)
+}
+
+// ReadParquet reads a parquet file written by WriteParquet or ParquetSink
+func ReadParquet(r io.ReaderAt, size int64) (RelativeValueUnits, error) {
+	rows, err := parquet.Read[parquetRVU](r, size)
+	if err != nil {
+		return nil, err
+	}
+	rvus := make(RelativeValueUnits, len(rows))
+	for i, row := range rows {
+		if rvus[i], err = fromParquetRVU(row); err != nil {
+			return nil, err
+		}
+	}
+	// files written before the natural key existed don't have it
+	return rvus, rvus.setMissingKeys()
+}
+
+// WriteParquet writes r to w as a single zstd compressed parquet file
+func (r RelativeValueUnits) WriteParquet(w io.Writer) error {
+	pw := parquet.NewGenericWriter[parquetRVU](w, parquet.Compression(&zstd.Codec{}))
+	if err := r.writeParquet(pw); err != nil {
+		return errors.Join(err, pw.Close())
+	}
+	return pw.Close()
+}
+
+func (r RelativeValueUnits) writeParquet(pw *parquet.GenericWriter[parquetRVU]) error {
+	rows := make([]parquetRVU, len(r))
+	for i, rvu := range r {
+		rows[i] = toParquetRVU(rvu)
+	}
+	_, err := pw.Write(rows)
+	return err
+}
+
+// ParquetSink exports each release to a parquet file, partitioned by the effective year
+// and quarter in hive style:
+//
+//	<Dir>/effective_year=2024/effective_quarter=3/<Name>_2024-07-01.parquet
+//
+// Like FileSink, files are written to a temporary file and renamed on Commit.
+type ParquetSink struct {
+	Dir  string
+	Name string // defaults to rvu
+
+	file *os.File
+	pw   *parquet.GenericWriter[parquetRVU]
+	path string
+}
+
+func (p *ParquetSink) CreateSchema(ctx context.Context) error {
+	return os.MkdirAll(p.Dir, 0o755)
+}
+
+func (p *ParquetSink) BeginRelease(ctx context.Context, release DataConfig) error {
+	name := p.Name
+	if name == "" {
+		name = "rvu"
+	}
+	date := release.EffectiveDate.Time
+	dir := filepath.Join(
+		p.Dir,
+		fmt.Sprintf("effective_year=%d", date.Year()),
+		fmt.Sprintf("effective_quarter=%d", (int(date.Month())+2)/3),
+	)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	p.path = filepath.Join(dir, fmt.Sprintf("%s_%s.parquet", name, date.Format(time.DateOnly)))
+	file, err := os.CreateTemp(dir, filepath.Base(p.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	// CreateTemp only gives the owner access
+	if err := file.Chmod(0o644); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	p.file = file
+	p.pw = parquet.NewGenericWriter[parquetRVU](file, parquet.Compression(&zstd.Codec{}))
+	return nil
+}
+
+func (p *ParquetSink) WriteBatch(ctx context.Context, rvus RelativeValueUnits) error {
+	return rvus.writeParquet(p.pw)
+}
+
+func (p *ParquetSink) Commit(ctx context.Context) error {
+	if err := errors.Join(p.pw.Close(), p.file.Close()); err != nil {
+		return err
+	}
+	err := os.Rename(p.file.Name(), p.path)
+	p.file = nil
+	return err
+}
+
+func (p *ParquetSink) Abort(ctx context.Context) error {
+	if p.file == nil {
+		return nil
+	}
+	p.file.Close()
+	err := os.Remove(p.file.Name())
+	p.file = nil
+	return err
+}
+
+func nullStringPtr(s sql.NullString) *string {
+	if !s.Valid {
+		return nil
+	}
+	return &s.String
+}
+
+func nullFloat64Ptr(f sql.NullFloat64) *float64 {
+	if !f.Valid {
+		return nil
+	}
+	return &f.Float64
+}
+
+func decimalStringPtr(d Decimal) *string {
+	if !d.Valid {
+		return nil
+	}
+	s := d.String()
+	return &s
+}
+
+func nullInt64Ptr(i sql.NullInt64) *int64 {
+	if !i.Valid {
+		return nil
+	}
+	return &i.Int64
+}
+
+func ptrNullString(s *string) sql.NullString {
+	if s == nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: *s, Valid: true}
+}
+
+func ptrNullFloat64(f *float64) sql.NullFloat64 {
+	if f == nil {
+		return sql.NullFloat64{}
+	}
+	return sql.NullFloat64{Float64: *f, Valid: true}
+}
+
+func ptrDecimal(s *string) (Decimal, error) {
+	d := Decimal{}
+	if s == nil {
+		return d, nil
+	}
+	err := d.scanText(*s)
+	return d, err
+}
+
+func ptrNullInt64(i *int64) sql.NullInt64 {
+	if i == nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: *i, Valid: true}
+}
@@ -0,0 +1,234 @@
+package cmsrvu
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jmoiron/sqlx"
+)
+
+// Locality identifies a payment locality, locality numbers are only unique within a
+// Medicare administrative contractor
+type Locality struct {
+	MAC    string
+	Number string // two digits, ie 01
+}
+
+// ParseLocality parses a locality in the form returned by Locality.String, ie 01182-18
+func ParseLocality(s string) (Locality, error) {
+	mac, number, ok := strings.Cut(cleanString(s), "-")
+	if !ok || !isDigits(mac) || !isDigits(number) {
+		return Locality{}, fmt.Errorf("invalid locality %q, expected mac-locality ie 01182-18", s)
+	}
+	return Locality{MAC: mac, Number: localityNumber(number)}, nil
+}
+
+func (l Locality) String() string { return l.MAC + "-" + l.Number }
+
+// QueryGPCIs reads the GPCIs in effect on dateOfService from schema.table, the release
+// effective on or before it, or every release if dateOfService isn't valid. It works
+// with postgres and sqlite (schema is ignored).
+func QueryGPCIs(ctx context.Context, db *sqlx.DB, schema, table string, dateOfService pgtype.Date) (GPCIs, error) {
+	names, err := dbTableNames(db, schema, table)
+	if err != nil {
+		return nil, err
+	}
+	q, args := queryInEffect(names, gpciColumns, dateOfService)
+	gpcis := GPCIs{}
+	if err := db.SelectContext(ctx, &gpcis, db.Rebind(q+"\norder by _effective_date, mac, locality_number"), args...); err != nil {
+		return nil, err
+	}
+	return gpcis, nil
+}
+
+// GPCIIndex looks up the GPCIs of a locality on a date of service from memory. It
+// isn't modified after it's built, so it's safe for concurrent use.
+type GPCIIndex struct {
+	index localityIndex[GPCI]
+}
+
+// NewGPCIIndex indexes gpcis, which can span any number of releases
+func NewGPCIIndex(gpcis GPCIs) *GPCIIndex {
+	return &GPCIIndex{newLocalityIndex(gpcis, func(g GPCI) (pgtype.Date, Locality, rvuKey) {
+		return g.EffectiveDate, g.Locality(), rvuKey{}
+	})}
+}
+
+// Lookup returns the GPCIs of locality in the release in effect on dateOfService. ok is
+// false if there's no such release or the locality isn't in it.
+func (x *GPCIIndex) Lookup(locality Locality, dateOfService time.Time) (GPCI, bool) {
+	return x.index.lookup(locality, rvuKey{}, dateOfService)
+}
+
+// localityIndex indexes a reference file with a row per locality (and code, for files
+// with a row per code and locality) by release
+type localityIndex[T any] struct {
+	releases []time.Time // effective dates, sorted
+	rows     map[localityIndexKey]T
+}
+
+type localityIndexKey struct {
+	release  string // effective date, yyyy-mm-dd
+	locality Locality
+	code     rvuKey // zero for files without codes
+}
+
+func newLocalityIndex[T any](rows []T, key func(T) (pgtype.Date, Locality, rvuKey)) localityIndex[T] {
+	x := localityIndex[T]{rows: map[localityIndexKey]T{}}
+	for _, row := range rows {
+		effectiveDate, locality, code := key(row)
+		x.rows[localityIndexKey{effectiveDate.Time.Format(time.DateOnly), locality, code}] = row
+		x.releases = append(x.releases, effectiveDate.Time)
+	}
+	slices.SortFunc(x.releases, time.Time.Compare)
+	x.releases = slices.CompactFunc(x.releases, time.Time.Equal)
+	return x
+}
+
+// lookup returns the row for locality and code in the release in effect on
+// dateOfService
+func (x localityIndex[T]) lookup(locality Locality, code rvuKey, dateOfService time.Time) (row T, ok bool) {
+	release, ok := releaseOn(x.releases, dateOfService)
+	if !ok {
+		return row, false
+	}
+	row, ok = x.rows[localityIndexKey{release.Format(time.DateOnly), locality, code}]
+	return row, ok
+}
+
+// Setting is the place of service a payment amount is for
+type Setting string
+
+const (
+	SettingFacility    Setting = "facility"
+	SettingNonFacility Setting = "nonfacility"
+)
+
+// ParseSetting parses facility or nonfacility (non-facility also works)
+func ParseSetting(s string) (Setting, error) {
+	switch setting := Setting(strings.ReplaceAll(strings.ToLower(cleanString(s)), "-", "")); setting {
+	case SettingFacility, SettingNonFacility:
+		return setting, nil
+	}
+	return "", fmt.Errorf("invalid setting %q, expected facility or nonfacility", s)
+}
+
+var (
+	// ErrNoRVU is returned by Price when the code isn't in the release in effect
+	ErrNoRVU = errors.New("no rvus")
+	// ErrNoGPCI is returned by Price when the locality isn't in the release in effect
+	ErrNoGPCI = errors.New("no gpcis")
+	// ErrNotPriced is returned by Price for codes that aren't paid from their rvus, see
+	// StatusCode
+	ErrNotPriced = errors.New("not priced from rvus")
+)
+
+// Payment is a locality adjusted physician fee schedule amount, see Pricer.Price
+type Payment struct {
+	HCPCS         string
+	Modifier      string
+	Locality      Locality
+	DateOfService time.Time
+	Setting       Setting
+	// Amount is the payment for Setting, Facility or NonFacility
+	Amount      Decimal
+	Facility    Decimal // null if the facility NA indicator is set
+	NonFacility Decimal // null if the non-facility NA indicator is set
+	RVU         RelativeValueUnit
+	GPCI        GPCI
+	// OPPSCap is the code's OPPS cap in the locality if it applies, FacilityCapped and
+	// NonFacilityCapped are set if the cap was less than the fee schedule amount
+	OPPSCap           *OPPSCap
+	FacilityCapped    bool
+	NonFacilityCapped bool
+}
+
+// Pricer prices services from the rvu and GPCI releases in effect on their date of
+// service. It's safe for concurrent use, as are its indexes. OPPSCaps is optional,
+// without it imaging services aren't capped. AnesthesiaCFs and BaseUnits are only
+// needed for PriceAnesthesia and PriceAnesthesiaCode.
+type Pricer struct {
+	RVUs          *RVUIndex
+	GPCIs         *GPCIIndex
+	OPPSCaps      *OPPSCapIndex
+	AnesthesiaCFs *AnesthesiaCFIndex
+	BaseUnits     BaseUnitSource
+}
+
+// Price returns the payment for hcpcs and modifier (as in the rvu file, "" for none) in
+// locality on dateOfService:
+//
+//	[(work rvu × work gpci) + (pe rvu × pe gpci) + (mp rvu × mp gpci)] × conversion factor
+//
+// using the facility or non-facility practice expense rvu, rounded to the cent - see
+// RelativeValueUnit.LocalityPayment. Both amounts are returned along with the one for
+// setting, which is null if the setting's NA indicator is set. If the OPPS cap applies
+// to the code (see RelativeValueUnit.OPPSCapApplies) and p.OPPSCaps has one for it in
+// locality, each amount is the lesser of the fee schedule amount and the cap. Codes
+// that aren't paid from their rvus (ie bundled, excluded or contractor priced codes)
+// return ErrNotPriced, anesthesia codes are priced by PriceAnesthesiaCode.
+func (p Pricer) Price(hcpcs, modifier string, locality Locality, dateOfService time.Time, setting Setting) (Payment, error) {
+	pay := Payment{HCPCS: hcpcs, Modifier: modifier, Locality: locality, DateOfService: dateOfService, Setting: setting}
+	if setting != SettingFacility && setting != SettingNonFacility {
+		return pay, fmt.Errorf("invalid setting %q", setting)
+	}
+	rvu, ok := p.RVUs.Lookup(hcpcs, modifier, dateOfService)
+	if !ok {
+		return pay, fmt.Errorf("%w for %s %s on %s", ErrNoRVU, hcpcs, modifier, dateOfService.Format(time.DateOnly))
+	}
+	pay.RVU = rvu
+	if status := rvu.StatusCode; !status.IsPayable() || status.String() == "C" {
+		return pay, fmt.Errorf("%s %s has status %s: %w", hcpcs, modifier, status, ErrNotPriced)
+	}
+	g, ok := p.GPCIs.Lookup(locality, dateOfService)
+	if !ok {
+		return pay, fmt.Errorf("%w for locality %s on %s", ErrNoGPCI, locality, dateOfService.Format(time.DateOnly))
+	}
+	pay.GPCI = g
+
+	pay.Facility, pay.NonFacility = rvu.LocalityPayment(g)
+	if p.OPPSCaps != nil && rvu.OPPSCapApplies() {
+		if c, ok := p.OPPSCaps.Lookup(hcpcs, modifier, locality, dateOfService); ok {
+			pay.OPPSCap = &c
+			pay.Facility, pay.FacilityCapped = capAt(pay.Facility, c.FacilityPrice)
+			pay.NonFacility, pay.NonFacilityCapped = capAt(pay.NonFacility, c.NonFacilityPrice)
+		}
+	}
+	pay.Amount = pay.NonFacility
+	if setting == SettingFacility {
+		pay.Amount = pay.Facility
+	}
+	return pay, nil
+}
+
+// LocalityPayment returns the facility and non-facility payment amounts in g's
+// locality, each rvu adjusted by its GPCI and the total × conversion factor, rounded
+// to the cent once at the end. Blank rvus count as zero. An amount is null if the
+// setting's NA indicator is set.
+func (r RelativeValueUnit) LocalityPayment(g GPCI) (facility, nonFacility Decimal) {
+	work := r.WRVU.orZero().Mul(g.WorkGPCI)
+	mp := r.MalpracticeRVU.orZero().Mul(g.MPGPCI)
+	amount := func(pe Decimal) Decimal {
+		return work.Add(pe.orZero().Mul(g.PEGPCI)).Add(mp).Mul(r.ConversionFactor).Round(2)
+	}
+	if !r.FacilityNAIndicator {
+		facility = amount(r.FacilityPERVU)
+	}
+	if !r.NonFacilityNAIndicator {
+		nonFacility = amount(r.NonFacilityPERVU)
+	}
+	return facility, nonFacility
+}
+
+// orZero returns d, or zero if it's null
+func (d Decimal) orZero() Decimal {
+	if !d.Valid {
+		return NewDecimal(0, 0)
+	}
+	return d
+}
@@ -0,0 +1,156 @@
+package cmsrvu
+
+import (
+	"encoding/csv"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+var july2024 = pgtype.Date{Time: time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC), Valid: true}
+
+// rvusFromCSV parses rows of the rvu file into the release effective July 2024
+func rvusFromCSV(t *testing.T, rows string) RelativeValueUnits {
+	t.Helper()
+	records, err := csv.NewReader(strings.NewReader(rows)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rvus, err := RVUsFromRecords(records, ReleaseMetadata{Source: "test"}.Map(), july2024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rvus
+}
+
+// rows from PPRRVU24_JUL.csv
+const pprrvu24Jul = `70450,TC,Ct head/brain w/o dye,A,,0.00,2.06,,2.06,NA,0.01,2.07,2.07,1,XXX,0.00,0.00,0.00,4,0,0,0,0,,33.2875,01,0,88,3.18,3.18,0.02
+70450,26,Ct head/brain w/o dye,A,,0.85,0.30,,0.30,,0.04,1.19,1.19,1,XXX,0.00,0.00,0.00,4,0,0,0,0,,33.2875,09,0,88,0.00,0.00,0.00
+99213,,Office o/p est low 20 min,A,,1.30,1.33,,0.56,,0.10,2.73,1.96,0,XXX,0.00,0.00,0.00,0,0,0,0,0,,33.2875,09,0,99,0.00,0.00,0.00
+A4262,,Temporary tear duct plug,B,,0.00,0.00,,0.00,,0.00,0.00,0.00,9,XXX,0.00,0.00,0.00,9,9,9,9,9,,33.2875,09,0,99,0.00,0.00,0.00
+`
+
+// test GPCIs, with a work GPCI at the 1.0 floor and ones well above it
+func testGPCIs(t *testing.T) GPCIs {
+	return GPCIs{
+		{EffectiveDate: july2024, MAC: "10112", LocalityNumber: "00", WorkGPCI: mustDecimal(t, "1.000"), PEGPCI: mustDecimal(t, "0.869"), MPGPCI: mustDecimal(t, "0.575")},
+		{EffectiveDate: july2024, MAC: "13202", LocalityNumber: "01", WorkGPCI: mustDecimal(t, "1.094"), PEGPCI: mustDecimal(t, "1.308"), MPGPCI: mustDecimal(t, "1.628")},
+	}
+}
+
+func TestLocalityPayment(t *testing.T) {
+	rvus := rvusFromCSV(t, pprrvu24Jul)
+	gpcis := testGPCIs(t)
+	national := GPCI{WorkGPCI: mustDecimal(t, "1"), PEGPCI: mustDecimal(t, "1"), MPGPCI: mustDecimal(t, "1")}
+	tests := []struct {
+		name                  string
+		rvu                   RelativeValueUnit
+		gpci                  GPCI
+		facility, nonFacility string
+	}{
+		// total rvu × cf: 1.96 × 33.2875 = 65.2435, 2.73 × 33.2875 = 90.874875
+		{"99213 national", rvus[2], national, "65.24", "90.87"},
+		// (1.30 × 1.000 + 0.56 × 0.869 + 0.10 × 0.575) × 33.2875 = 61.38681025
+		// (1.30 × 1.000 + 1.33 × 0.869 + 0.10 × 0.575) × 33.2875 = 83.660475125
+		{"99213", rvus[2], gpcis[0], "61.39", "83.66"},
+		// (1.30 × 1.094 + 0.56 × 1.308 + 0.10 × 1.628) × 33.2875 = 77.1431155
+		// (1.30 × 1.094 + 1.33 × 1.308 + 0.10 × 1.628) × 33.2875 = 110.668954
+		{"99213 high gpcis", rvus[2], gpcis[1], "77.14", "110.67"},
+		// the facility NA indicator is set
+		{"70450 TC", rvus[0], gpcis[0], "", "59.78"},
+		{"70450 26", rvus[1], gpcis[1], "46.18", "46.18"},
+	}
+	for _, tt := range tests {
+		facility, nonFacility := tt.rvu.LocalityPayment(tt.gpci)
+		if facility.String() != tt.facility || nonFacility.String() != tt.nonFacility {
+			t.Errorf("%s: LocalityPayment = %q, %q, want %q, %q", tt.name, facility, nonFacility, tt.facility, tt.nonFacility)
+		}
+	}
+}
+
+func TestLocalityPaymentRounding(t *testing.T) {
+	national := GPCI{WorkGPCI: mustDecimal(t, "1"), PEGPCI: mustDecimal(t, "1"), MPGPCI: mustDecimal(t, "1")}
+	tests := []struct {
+		wrvu, pervu, mprvu string
+		want               string
+	}{
+		{"0.40", "0.00", "0.00", "13.32"}, // 13.315 exactly, half rounds away from zero
+		{"0.20", "0.00", "0.00", "6.66"},  // 6.6575
+		// 0.013 × 33.2875 = 0.4327375 for each, the total is rounded once: 1.2982125,
+		// not 0.43 + 0.43 + 0.43
+		{"0.013", "0.013", "0.013", "1.30"},
+		{"", "", "", "0.00"}, // blank rvus count as zero
+	}
+	for _, tt := range tests {
+		rvu := RelativeValueUnit{
+			WRVU:             mustDecimal(t, tt.wrvu),
+			FacilityPERVU:    mustDecimal(t, tt.pervu),
+			NonFacilityPERVU: mustDecimal(t, tt.pervu),
+			MalpracticeRVU:   mustDecimal(t, tt.mprvu),
+			ConversionFactor: mustDecimal(t, "33.2875"),
+		}
+		facility, nonFacility := rvu.LocalityPayment(national)
+		if facility.String() != tt.want || nonFacility.String() != tt.want {
+			t.Errorf("%s/%s/%s: LocalityPayment = %q, %q, want %q", tt.wrvu, tt.pervu, tt.mprvu, facility, nonFacility, tt.want)
+		}
+	}
+}
+
+func TestPrice(t *testing.T) {
+	rvus := rvusFromCSV(t, pprrvu24Jul)
+	// the July 2024 file has no calculation flags set, flag the TC so the cap applies
+	rvus[0].CalculationFlag.Int64, rvus[0].CalculationFlag.Valid = 1, true
+	caps := OPPSCaps{
+		{EffectiveDate: july2024, HCPCS: "70450", Modifier: "TC", MAC: "10112", LocalityNumber: "00", NonFacilityPrice: mustDecimal(t, "55.125")},
+		{EffectiveDate: july2024, HCPCS: "70450", Modifier: "TC", MAC: "13202", LocalityNumber: "01", NonFacilityPrice: mustDecimal(t, "95.00")},
+		// not flagged, so not capped
+		{EffectiveDate: july2024, HCPCS: "70450", Modifier: "26", MAC: "10112", LocalityNumber: "00", FacilityPrice: mustDecimal(t, "1.00"), NonFacilityPrice: mustDecimal(t, "1.00")},
+	}
+	pricer := Pricer{RVUs: NewRVUIndex(rvus), GPCIs: NewGPCIIndex(testGPCIs(t)), OPPSCaps: NewOPPSCapIndex(caps)}
+	low, high := Locality{"10112", "00"}, Locality{"13202", "01"}
+	dos := time.Date(2024, 8, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name            string
+		hcpcs, modifier string
+		locality        Locality
+		dos             time.Time
+		setting         Setting
+		amount          string
+		capped          bool
+		err             error
+	}{
+		{"nonfacility", "99213", "", low, dos, SettingNonFacility, "83.66", false, nil},
+		{"facility", "99213", "", low, dos, SettingFacility, "61.39", false, nil},
+		{"facility NA", "70450", "TC", low, dos, SettingFacility, "", false, nil},
+		// 59.78 capped at 55.125, to the cent
+		{"capped", "70450", "TC", low, dos, SettingNonFacility, "55.13", true, nil},
+		// 90.23 is under the cap
+		{"under the cap", "70450", "TC", high, dos, SettingNonFacility, "90.23", false, nil},
+		{"cap doesn't apply", "70450", "26", low, dos, SettingNonFacility, "37.74", false, nil},
+		{"before the release", "99213", "", low, time.Date(2024, 6, 30, 0, 0, 0, 0, time.UTC), SettingNonFacility, "", false, ErrNoRVU},
+		{"no such code", "99999", "", low, dos, SettingNonFacility, "", false, ErrNoRVU},
+		{"no such locality", "99213", "", Locality{"00000", "99"}, dos, SettingNonFacility, "", false, ErrNoGPCI},
+		{"bundled", "A4262", "", low, dos, SettingNonFacility, "", false, ErrNotPriced},
+	}
+	for _, tt := range tests {
+		pay, err := pricer.Price(tt.hcpcs, tt.modifier, tt.locality, tt.dos, tt.setting)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: Price error = %v, want %v", tt.name, err, tt.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		capped := pay.NonFacilityCapped
+		if tt.setting == SettingFacility {
+			capped = pay.FacilityCapped
+		}
+		if pay.Amount.String() != tt.amount || capped != tt.capped {
+			t.Errorf("%s: Price = %q capped %t, want %q capped %t", tt.name, pay.Amount, capped, tt.amount, tt.capped)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package cmsrvu
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jmoiron/sqlx"
+)
+
+// RVUQuery filters the rows returned by QueryRVUs, zero values don't filter
+type RVUQuery struct {
+	HCPCS     []string // any of these codes
+	HCPCSFrom string   // codes from HCPCSFrom up to and including HCPCSTo
+	HCPCSTo   string
+	// ModifierCodes matches any of these modifiers, "" matches rows without a modifier
+	ModifierCodes []string
+	StatusCodes   []string
+	// EffectiveDate only returns the release effective on this date
+	EffectiveDate pgtype.Date
+	// DateOfService only returns the release in effect on this date, the latest one
+	// effective on or before it
+	DateOfService pgtype.Date
+	// Source only returns rows loaded from this url. Tables loaded in LoadModeInsert
+	// before the natural key existed can have rows for every posting of a release,
+	// Source picks one.
+	Source string
+	Limit  int // no limit if 0
+	Offset int
+}
+
+// QueryRVUs reads the rows matching q from schema.table, ordered by effective date,
+// hcpcs and modifier so Limit and Offset can be used to page through them. It works
+// with postgres and sqlite (schema is ignored).
+func QueryRVUs(ctx context.Context, db *sqlx.DB, schema, table string, q RVUQuery) (RelativeValueUnits, error) {
+	sqlite := db.DriverName() == DriverSQLite
+	names, err := dbTableNames(db, schema, table)
+	if err != nil {
+		return nil, err
+	}
+
+	where, args := q.where(names)
+	query := fmt.Sprintf("select %s from %s", strings.Join(rvuColumns, ", "), names.qualified(""))
+	if len(where) > 0 {
+		query += "\nwhere " + strings.Join(where, "\nand ")
+	}
+	query += "\norder by _effective_date, hcpcs, modifier_code, _variant, _id_hash"
+	switch {
+	case q.Limit > 0:
+		query += "\nlimit ?"
+		args = append(args, q.Limit)
+	case q.Offset > 0 && sqlite:
+		// sqlite doesn't allow an offset without a limit
+		query += "\nlimit -1"
+	}
+	if q.Offset > 0 {
+		query += "\noffset ?"
+		args = append(args, q.Offset)
+	}
+
+	rvus := RelativeValueUnits{}
+	if err := db.SelectContext(ctx, &rvus, db.Rebind(query), args...); err != nil {
+		return nil, err
+	}
+	return rvus, nil
+}
+
+// where builds the conditions and arguments for q, with ? placeholders
+func (q RVUQuery) where(names tableNames) ([]string, []any) {
+	where := []string{}
+	args := []any{}
+	in := func(column string, values []string) {
+		where = append(where, fmt.Sprintf("%s in (%s)", column, placeholders(len(values))))
+		for _, v := range values {
+			args = append(args, v)
+		}
+	}
+
+	if len(q.HCPCS) > 0 {
+		in("hcpcs", q.HCPCS)
+	}
+	if q.HCPCSFrom != "" {
+		where = append(where, "hcpcs >= ?")
+		args = append(args, q.HCPCSFrom)
+	}
+	if q.HCPCSTo != "" {
+		where = append(where, "hcpcs <= ?")
+		args = append(args, q.HCPCSTo)
+	}
+	if len(q.ModifierCodes) > 0 {
+		mods := []string{}
+		nullMod := false
+		for _, m := range q.ModifierCodes {
+			if m == "" {
+				nullMod = true
+				continue
+			}
+			mods = append(mods, m)
+		}
+		conds := []string{}
+		if len(mods) > 0 {
+			conds = append(conds, fmt.Sprintf("modifier_code in (%s)", placeholders(len(mods))))
+			for _, m := range mods {
+				args = append(args, m)
+			}
+		}
+		if nullMod {
+			conds = append(conds, "modifier_code is null")
+		}
+		where = append(where, "("+strings.Join(conds, " or ")+")")
+	}
+	if len(q.StatusCodes) > 0 {
+		in("status_code", q.StatusCodes)
+	}
+	if q.Source != "" {
+		where = append(where, "_source = ?")
+		args = append(args, q.Source)
+	}
+	if q.EffectiveDate.Valid {
+		where = append(where, "_effective_date = ?")
+		args = append(args, q.EffectiveDate.Time.Format(time.DateOnly))
+	}
+	if q.DateOfService.Valid {
+		where = append(where, fmt.Sprintf(
+			"_effective_date = (select max(_effective_date) from %s where _effective_date <= ?)",
+			names.qualified(""),
+		))
+		args = append(args, q.DateOfService.Time.Format(time.DateOnly))
+	}
+	return where, args
+}
+
+// placeholders returns n comma separated ? placeholders
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
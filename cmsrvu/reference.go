@@ -0,0 +1,164 @@
+package cmsrvu
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jmoiron/sqlx"
+)
+
+// Alongside the rvu file each archive has locality level reference files (GPCIs, ...).
+// They're small, so rather than the history tables, change logs and load modes of the
+// rvu table each is loaded into an effective dated table keyed on the effective date
+// and locality, written the same way for postgres and sqlite. Reloading a release
+// replaces its rows.
+
+// dbTableNames validates schema and table for db's driver, schema is ignored for sqlite
+func dbTableNames(db *sqlx.DB, schema, table string) (tableNames, error) {
+	if db.DriverName() == DriverSQLite {
+		return newSQLiteTableNames(table)
+	}
+	return newTableNames(schema, table)
+}
+
+// createReferenceTable runs ddl, a create table statement with %[1]s for the table,
+// %[2]s for the timestamp type and %[3]s for the decimal type, which differ between
+// postgres and sqlite. Sqlite would store numeric columns as reals, so decimals are
+// text.
+func createReferenceTable(ctx context.Context, db *sqlx.DB, names tableNames, ddl string) (sql.Result, error) {
+	timestamp, decimal := "timestamptz", "numeric"
+	if db.DriverName() == DriverSQLite {
+		timestamp, decimal = "timestamp", "text"
+	} else if _, err := db.ExecContext(ctx, "create schema if not exists "+names.quotedSchema()); err != nil {
+		return nil, err
+	}
+	return db.ExecContext(ctx, fmt.Sprintf(ddl, names.qualified(""), timestamp, decimal))
+}
+
+// putReferenceRows writes rows to names in a single transaction, rows with the same
+// key are replaced. The db tags of T must include columns, and the key must have a
+// unique constraint. It returns the number of rows written.
+func putReferenceRows[T any](ctx context.Context, db *sqlx.DB, names tableNames, columns, key []string, rows []T) (int64, error) {
+	values := make([]string, len(columns))
+	updates := []string{}
+	for i, c := range columns {
+		values[i] = ":" + c
+		if c == "_effective_date" && db.DriverName() == DriverSQLite {
+			values[i] = "date(:_effective_date)"
+		}
+		if !slices.Contains(key, c) {
+			updates = append(updates, fmt.Sprintf("%[1]s = excluded.%[1]s", c))
+		}
+	}
+	q := fmt.Sprintf(
+		"insert into %s (%s) values (%s)\non conflict (%s) do update set %s",
+		names.qualified(""), strings.Join(columns, ", "), strings.Join(values, ", "),
+		strings.Join(key, ", "), strings.Join(updates, ", "),
+	)
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.PrepareNamedContext(ctx, q)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	var n int64
+	for _, row := range rows {
+		res, err := stmt.ExecContext(ctx, row)
+		if err != nil {
+			return n, err
+		}
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return n, err
+		}
+		n += rows
+	}
+	return n, tx.Commit()
+}
+
+// deleteReferenceRows deletes the rows of the release effective on effectiveDate from
+// names, or only those loaded from source if it isn't empty
+func deleteReferenceRows(ctx context.Context, tx *sqlx.Tx, names tableNames, effectiveDate pgtype.Date, source string) error {
+	q := fmt.Sprintf(
+		"delete from %s where _effective_date = ? and (cast(? as text) = '' or _source = ?)",
+		names.qualified(""),
+	)
+	_, err := tx.ExecContext(ctx, tx.Rebind(q), effectiveDate.Time.Format(time.DateOnly), source, source)
+	return err
+}
+
+// referenceRowsExist reports whether names has any rows of the release loaded from
+// source
+func referenceRowsExist(ctx context.Context, db *sqlx.DB, names tableNames, effectiveDate pgtype.Date, source string) (bool, error) {
+	q := fmt.Sprintf(
+		"select exists (select 1 from %s where _effective_date = ? and _source = ?)",
+		names.qualified(""),
+	)
+	var exists bool
+	err := db.QueryRowContext(ctx, db.Rebind(q), effectiveDate.Time.Format(time.DateOnly), source).Scan(&exists)
+	return exists, err
+}
+
+// queryInEffect returns a select of columns from names, limited to the release in
+// effect on dateOfService if it's valid, with ? placeholders
+func queryInEffect(names tableNames, columns []string, dateOfService pgtype.Date) (string, []any) {
+	q := fmt.Sprintf("select %s from %s", strings.Join(columns, ", "), names.qualified(""))
+	if !dateOfService.Valid {
+		return q, nil
+	}
+	q += fmt.Sprintf(
+		"\nwhere _effective_date = (select max(_effective_date) from %s where _effective_date <= ?)",
+		names.qualified(""),
+	)
+	return q, []any{dateOfService.Time.Format(time.DateOnly)}
+}
+
+// fetchCSV downloads the archive for spec and reads the file matching defaultRegex
+// (or WithFileRegex) after the header matched by isHeader. spec.FileRegex is for the
+// rvu file, so it's ignored.
+func fetchCSV(ctx context.Context, spec ReleaseSpec, opts []Option, defaultRegex string, isHeader HeaderFunc) ([]string, [][]string, ReleaseMetadata, error) {
+	spec.FileRegex = defaultRegex
+	o := newFetchOptions(spec, opts)
+	data, md, err := fetchArchive(ctx, spec, o)
+	if err != nil {
+		return nil, nil, md, err
+	}
+	header, records, err := CSVFromZipHeader(data, o.fileRegex, isHeader)
+	return header, records, md, err
+}
+
+// columnIndex returns the index of the first header cell that match accepts, cells are
+// cleaned and upper cased first. It's -1 if no cell matches.
+func columnIndex(header []string, match func(cell string) bool) int {
+	return slices.IndexFunc(header, func(cell string) bool {
+		return match(strings.ToUpper(cleanString(cell)))
+	})
+}
+
+// containsAll returns a columnIndex matcher for cells containing every word
+func containsAll(words ...string) func(string) bool {
+	return func(cell string) bool {
+		for _, w := range words {
+			if !strings.Contains(cell, w) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// isDigits reports whether s is a non-empty string of digits
+func isDigits(s string) bool {
+	return s != "" && strings.Trim(s, "0123456789") == ""
+}
@@ -1,8 +1,10 @@
 package cmsrvu
 
 import (
+	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"fmt"
 	"regexp"
 	"strconv"
 	"strings"
@@ -19,53 +21,57 @@ import (
 //     this captures the original files code in the XCode field and a reasonable label from the pdf
 //     document in the X field
 type RelativeValueUnit struct {
-	Source                                         string          `db:"_source"`                                                            // meta - should be the source url
-	ExtractTime                                    time.Time       `db:"_extract_time"`                                                      // meta - attempts to capture actual extract (or get) time
-	LastModified                                   time.Time       `db:"_last_modified"`                                                     // meta - taken from last-modified header in http response
-	IDHash                                         string          `json:"_id_hash,omitempty" db:"_id_hash" pgtype:"text" primarykey:"true"` // hash of identifying fields
-	EffectiveDate                                  pgtype.Date     `db:"_effective_date" idhash:"true"`                                      // added field
-	HCPCS                                          string          `csv:"HCPCS" db:"hcpcs" idhash:"true"`
-	ModifierCode                                   sql.NullString  `csv:"MOD" db:"modifier_code" idhash:"true"`
-	Modifier                                       sql.NullString  `db:"modifier" idhash:"true"` // added field
-	Description                                    sql.NullString  `csv:"DESCRIPTION" db:"description" idhash:"true"`
-	StatusCode                                     sql.NullString  `csv:"STATUS CODE" db:"status_code" idhash:"true"`
-	Status                                         sql.NullString  `db:"status" idhash:"true"` // added field
-	NotUsedForMedicarePayment                      bool            `csv:"NOT USED FOR MEDICARE  PAYMENT" db:""`
-	WRVU                                           sql.NullFloat64 `csv:"WORK RVU" db:"wrvu" idhash:"true"`
-	NonFacilityPERVU                               sql.NullFloat64 `csv:"NON-FAC PE RVU" db:"nonfacility_pervu" idhash:"true"`
-	NonFacilityNAIndicator                         bool            `csv:"NON-FAC NA INDICATOR" db:"nonfacility_na_indicator" idhash:"true"`
-	FacilityPERVU                                  sql.NullFloat64 `csv:"FACILITY PE RVU" db:"facility_pervu" idhash:"true"`
-	FacilityNAIndicator                            bool            `csv:"FACILITY  NA INDICATOR" db:"facility_na_indicator" idhash:"true"`
-	MalpracticeRVU                                 sql.NullFloat64 `csv:"MP RVU" db:"malpractice_rvu" idhash:"true"`
-	TotalNonFacilityRVU                            sql.NullFloat64 `csv:"NON-FACILITY TOTAL" db:"total_nonfacility_rvu" idhash:"true"`
-	TotalFacilityRVU                               sql.NullFloat64 `csv:"FACILITY TOTAL" db:"total_facility_rvu" idhash:"true"`
-	PCTCIndicator                                  sql.NullInt64   `csv:"PCTC IND" db:"pctc_indicator" idhash:"true"`
-	PCTC                                           sql.NullString  `db:"pctc" idhash:"true"`
-	GlobalSurgeryCode                              sql.NullString  `csv:"GLOB DAYS" db:"global_surgery_code" idhash:"true"`
-	GlobalSurgery                                  sql.NullString  `db:"global_surgery" idhash:"true"`
-	PreoperativePercentage                         sql.NullFloat64 `csv:"PRE OP" db:"preoperative_surgery" idhash:"true"`
-	IntraoperativePercentage                       sql.NullFloat64 `csv:"INTRA OP" db:"intraoperative_surgery" idhash:"true"`
-	PostoperativePercentage                        sql.NullFloat64 `csv:"POST OP" db:"postoperative_surgery" idhash:"true"`
-	MultipleProcedureCode                          sql.NullInt64   `csv:"MULT PROC" db:"multiple_procedure_code" idhash:"true"`
-	MultipleProcedure                              sql.NullString  `db:"multiple_procedure" idhash:"true"`
-	BilateralSurgeryCode                           sql.NullInt64   `csv:"BILAT SURG" db:"bilateral_surgery_code" idhash:"true"`
-	BilateralSurgery                               sql.NullString  `db:"bilateral_surgery" idhash:"true"`
-	AssistantAtSurgeryCode                         sql.NullInt64   `csv:"ASST SURG" db:"assistant_at_surgery_code" idhash:"true"`
-	AssistantAtSurgery                             sql.NullString  `db:"assistant_at_surgery" idhash:"true"`
-	CoSurgeonsCode                                 sql.NullInt64   `csv:"CO-SURG" db:"cosurgeons_code" idhash:"true"`
-	CoSurgeons                                     sql.NullString  `db:"cosurgeons" idhash:"true"`
-	TeamSurgeryCode                                sql.NullInt64   `csv:"TEAM SURG" db:"team_surgery_code" idhash:"true"`
-	TeamSurgery                                    sql.NullString  `db:"team_surgery" idhash:"true"`
-	EndoscopicBaseCode                             sql.NullString  `csv:"ENDO BASE" db:"endoscopic_base_code" idhash:"true"`
-	ConversionFactor                               sql.NullFloat64 `csv:"CONV FACTOR" db:"conversion_factor" idhash:"true"`
-	PhysicianSupervisionOfDiagnosticProceduresCode sql.NullString  `csv:"PHYSICIAN SUPERVISION OF DIAGNOSTIC PROCEDURES" db:"physician_supervision_of_diagnostic_procedures_code" idhash:"true"`
-	PhysicianSupervisionOfDiagnosticProcedures     sql.NullString  `db:"physician_supervision_of_diagnostic_procedures" idhash:"true"`
-	CalculationFlag                                sql.NullInt64   `csv:"CALCULATION FLAG" db:"calculation_flag" idhash:"true"`
-	DiagnosticImagingFamilyIndicator               sql.NullInt64   `csv:"DIAGNOSTIC IMAGING FAMILY INDICATOR" db:"diagnostic_imaging_family_indicator" idhash:"true"`
-	DiagnosticImagingFamily                        sql.NullString  `db:"diagnostic_imaging_family" idhash:"true"`
-	NonFacilityPEUsedForOppsPaymentAmount          sql.NullFloat64 `csv:"NON-FACILITY PE USED FOR OPPS PAYMENT AMOUNT" db:"nonfacility_pe_used_for_opps_payment_amount" idhash:"true"`
-	FacilityPEUsedForOppsPaymentAmount             sql.NullFloat64 `csv:"FACILITY PE USED FOR OPPS PAYMENT AMOUNT" db:"facility_pe_used_for_opps_payment_amount" idhash:"true"`
-	MalpracticeUsedForOppsPaymentAmount            sql.NullFloat64 `csv:"MP USED FOR OPPS PAYMENT AMOUNT" db:"malpractice_used_for_opps_payment_amount" idhash:"true"`
+	Source                                         string                      `db:"_source"`                                                            // meta - should be the source url
+	ExtractTime                                    time.Time                   `db:"_extract_time"`                                                      // meta - attempts to capture actual extract (or get) time
+	LastModified                                   time.Time                   `db:"_last_modified"`                                                     // meta - taken from last-modified header in http response
+	IDHash                                         string                      `json:"_id_hash,omitempty" db:"_id_hash" pgtype:"text" primarykey:"true"` // hash of identifying fields
+	NaturalKey                                     string                      `db:"_natural_key"`                                                       // identity of the row, see SetNaturalKey
+	Variant                                        int64                       `db:"_variant"`                                                           // 0 unless the hcpcs/modifier repeats in the release
+	ContentHash                                    string                      `db:"_content_hash"`                                                      // change detection, see SetContentHash
+	HashVersion                                    string                      `db:"_hash_version"`                                                      // the algorithm behind ContentHash
+	EffectiveDate                                  pgtype.Date                 `db:"_effective_date" idhash:"true"`                                      // added field
+	HCPCS                                          string                      `csv:"HCPCS" db:"hcpcs" idhash:"true"`
+	ModifierCode                                   sql.NullString              `csv:"MOD" db:"modifier_code" idhash:"true"`
+	Modifier                                       sql.NullString              `db:"modifier" idhash:"true"` // added field
+	Description                                    sql.NullString              `csv:"DESCRIPTION" db:"description" idhash:"true"`
+	StatusCode                                     StatusCode                  `csv:"STATUS CODE" db:"status_code" idhash:"true"`
+	Status                                         sql.NullString              `db:"status" idhash:"true"` // added field
+	NotUsedForMedicarePayment                      bool                        `csv:"NOT USED FOR MEDICARE  PAYMENT" db:""`
+	WRVU                                           Decimal                     `csv:"WORK RVU" db:"wrvu" idhash:"true"`
+	NonFacilityPERVU                               Decimal                     `csv:"NON-FAC PE RVU" db:"nonfacility_pervu" idhash:"true"`
+	NonFacilityNAIndicator                         bool                        `csv:"NON-FAC NA INDICATOR" db:"nonfacility_na_indicator" idhash:"true"`
+	FacilityPERVU                                  Decimal                     `csv:"FACILITY PE RVU" db:"facility_pervu" idhash:"true"`
+	FacilityNAIndicator                            bool                        `csv:"FACILITY  NA INDICATOR" db:"facility_na_indicator" idhash:"true"`
+	MalpracticeRVU                                 Decimal                     `csv:"MP RVU" db:"malpractice_rvu" idhash:"true"`
+	TotalNonFacilityRVU                            Decimal                     `csv:"NON-FACILITY TOTAL" db:"total_nonfacility_rvu" idhash:"true"`
+	TotalFacilityRVU                               Decimal                     `csv:"FACILITY TOTAL" db:"total_facility_rvu" idhash:"true"`
+	PCTCIndicator                                  PCTCIndicator               `csv:"PCTC IND" db:"pctc_indicator" idhash:"true"`
+	PCTC                                           sql.NullString              `db:"pctc" idhash:"true"`
+	GlobalSurgeryCode                              GlobalPeriod                `csv:"GLOB DAYS" db:"global_surgery_code" idhash:"true"`
+	GlobalSurgery                                  sql.NullString              `db:"global_surgery" idhash:"true"`
+	PreoperativePercentage                         sql.NullFloat64             `csv:"PRE OP" db:"preoperative_surgery" idhash:"true"`
+	IntraoperativePercentage                       sql.NullFloat64             `csv:"INTRA OP" db:"intraoperative_surgery" idhash:"true"`
+	PostoperativePercentage                        sql.NullFloat64             `csv:"POST OP" db:"postoperative_surgery" idhash:"true"`
+	MultipleProcedureCode                          MultipleProcedureIndicator  `csv:"MULT PROC" db:"multiple_procedure_code" idhash:"true"`
+	MultipleProcedure                              sql.NullString              `db:"multiple_procedure" idhash:"true"`
+	BilateralSurgeryCode                           BilateralSurgeryIndicator   `csv:"BILAT SURG" db:"bilateral_surgery_code" idhash:"true"`
+	BilateralSurgery                               sql.NullString              `db:"bilateral_surgery" idhash:"true"`
+	AssistantAtSurgeryCode                         AssistantAtSurgeryIndicator `csv:"ASST SURG" db:"assistant_at_surgery_code" idhash:"true"`
+	AssistantAtSurgery                             sql.NullString              `db:"assistant_at_surgery" idhash:"true"`
+	CoSurgeonsCode                                 CoSurgeonsIndicator         `csv:"CO-SURG" db:"cosurgeons_code" idhash:"true"`
+	CoSurgeons                                     sql.NullString              `db:"cosurgeons" idhash:"true"`
+	TeamSurgeryCode                                TeamSurgeryIndicator        `csv:"TEAM SURG" db:"team_surgery_code" idhash:"true"`
+	TeamSurgery                                    sql.NullString              `db:"team_surgery" idhash:"true"`
+	EndoscopicBaseCode                             sql.NullString              `csv:"ENDO BASE" db:"endoscopic_base_code" idhash:"true"`
+	ConversionFactor                               Decimal                     `csv:"CONV FACTOR" db:"conversion_factor" idhash:"true"`
+	PhysicianSupervisionOfDiagnosticProceduresCode SupervisionCode             `csv:"PHYSICIAN SUPERVISION OF DIAGNOSTIC PROCEDURES" db:"physician_supervision_of_diagnostic_procedures_code" idhash:"true"`
+	PhysicianSupervisionOfDiagnosticProcedures     sql.NullString              `db:"physician_supervision_of_diagnostic_procedures" idhash:"true"`
+	CalculationFlag                                sql.NullInt64               `csv:"CALCULATION FLAG" db:"calculation_flag" idhash:"true"`
+	DiagnosticImagingFamilyIndicator               ImagingFamilyIndicator      `csv:"DIAGNOSTIC IMAGING FAMILY INDICATOR" db:"diagnostic_imaging_family_indicator" idhash:"true"`
+	DiagnosticImagingFamily                        sql.NullString              `db:"diagnostic_imaging_family" idhash:"true"`
+	NonFacilityPEUsedForOppsPaymentAmount          Decimal                     `csv:"NON-FACILITY PE USED FOR OPPS PAYMENT AMOUNT" db:"nonfacility_pe_used_for_opps_payment_amount" idhash:"true"`
+	FacilityPEUsedForOppsPaymentAmount             Decimal                     `csv:"FACILITY PE USED FOR OPPS PAYMENT AMOUNT" db:"facility_pe_used_for_opps_payment_amount" idhash:"true"`
+	MalpracticeUsedForOppsPaymentAmount            Decimal                     `csv:"MP USED FOR OPPS PAYMENT AMOUNT" db:"malpractice_used_for_opps_payment_amount" idhash:"true"`
 }
 
 // func (*RelativeValueUnit) Unmarshal(data []byte)
@@ -79,10 +85,18 @@ func RVUFromRecord(in []string) (RelativeValueUnit, error) {
 	// fmt.Printf("%#v\n", in)
 	// process floats
 	floats := map[int]sql.NullFloat64{}
-	for _, fieldIndex := range []int{5, 6, 8, 10, 11, 12, 15, 16, 17, 24, 28, 29, 30} {
+	for _, fieldIndex := range []int{15, 16, 17} {
 		floats[fieldIndex] = toSQLNullFloat64(in[fieldIndex])
 	}
 
+	// process rvus, conversion factor and opps amounts as exact decimals
+	decimals := map[int]Decimal{}
+	for _, fieldIndex := range []int{5, 6, 8, 10, 11, 12, 24, 28, 29, 30} {
+		d, err := ParseDecimal(in[fieldIndex])
+		errs = append(errs, err)
+		decimals[fieldIndex] = d
+	}
+
 	// process ints
 	ints := map[int]sql.NullInt64{}
 	for _, fieldIndex := range []int{13, 18, 19, 20, 21, 22, 26, 27} {
@@ -100,47 +114,47 @@ func RVUFromRecord(in []string) (RelativeValueUnit, error) {
 		ModifierCode:              strs[1],
 		Modifier:                  ToModifier(strs[1]),
 		Description:               strs[2],
-		StatusCode:                strs[3],
+		StatusCode:                StatusCode{strs[3]},
 		Status:                    ToStatus(strs[3]),
 		NotUsedForMedicarePayment: cleanString(in[4]) != "",
-		WRVU:                      floats[5],
-		NonFacilityPERVU:          floats[6],
+		WRVU:                      decimals[5],
+		NonFacilityPERVU:          decimals[6],
 		NonFacilityNAIndicator:    cleanString(in[7]) == "NA",
-		FacilityPERVU:             floats[8],
+		FacilityPERVU:             decimals[8],
 		FacilityNAIndicator:       cleanString(in[9]) == "NA",
-		MalpracticeRVU:            floats[10],
-		TotalNonFacilityRVU:       floats[11],
-		TotalFacilityRVU:          floats[12],
-		PCTCIndicator:             ints[13],
+		MalpracticeRVU:            decimals[10],
+		TotalNonFacilityRVU:       decimals[11],
+		TotalFacilityRVU:          decimals[12],
+		PCTCIndicator:             PCTCIndicator{ints[13]},
 		PCTC:                      ToPCTC(ints[13]),
 		//  if ints[13].Valid { ToPCTC(int(ints[13].Int64) } else "",
 		// ToPCTC(int(ints[13].Int64)),
-		GlobalSurgeryCode:        strs[14],
+		GlobalSurgeryCode:        GlobalPeriod{strs[14]},
 		GlobalSurgery:            ToGlobalSurgery(strs[14]),
 		PreoperativePercentage:   floats[15],
 		IntraoperativePercentage: floats[16],
 		PostoperativePercentage:  floats[17],
 
-		MultipleProcedureCode:  ints[18],
+		MultipleProcedureCode:  MultipleProcedureIndicator{ints[18]},
 		MultipleProcedure:      ToMultipleProcedure(ints[18]),
-		BilateralSurgeryCode:   ints[19],
+		BilateralSurgeryCode:   BilateralSurgeryIndicator{ints[19]},
 		BilateralSurgery:       ToBilateralSurgery(ints[19]),
-		AssistantAtSurgeryCode: ints[20],
+		AssistantAtSurgeryCode: AssistantAtSurgeryIndicator{ints[20]},
 		AssistantAtSurgery:     ToAssistantAtSurgery(ints[20]),
-		CoSurgeonsCode:         ints[21],
+		CoSurgeonsCode:         CoSurgeonsIndicator{ints[21]},
 		CoSurgeons:             ToCosurgeons(ints[21]),
-		TeamSurgeryCode:        ints[22],
+		TeamSurgeryCode:        TeamSurgeryIndicator{ints[22]},
 		TeamSurgery:            ToTeamSurgery(ints[22]),
 		EndoscopicBaseCode:     strs[23],
-		ConversionFactor:       floats[24],
-		PhysicianSupervisionOfDiagnosticProceduresCode: strs[25],
+		ConversionFactor:       decimals[24],
+		PhysicianSupervisionOfDiagnosticProceduresCode: SupervisionCode{strs[25]},
 		PhysicianSupervisionOfDiagnosticProcedures:     ToPhysicianSupervisionOfDiagnosticProcedures(strs[25]),
 		CalculationFlag:                       ints[26],
-		DiagnosticImagingFamilyIndicator:      ints[27],
+		DiagnosticImagingFamilyIndicator:      ImagingFamilyIndicator{ints[27]},
 		DiagnosticImagingFamily:               ToDiagnosticImagingFamily(ints[27]),
-		NonFacilityPEUsedForOppsPaymentAmount: floats[28],
-		FacilityPEUsedForOppsPaymentAmount:    floats[29],
-		MalpracticeUsedForOppsPaymentAmount:   floats[30],
+		NonFacilityPEUsedForOppsPaymentAmount: decimals[28],
+		FacilityPEUsedForOppsPaymentAmount:    decimals[29],
+		MalpracticeUsedForOppsPaymentAmount:   decimals[30],
 	}
 
 	return rvu, errors.Join(errs...)
@@ -151,13 +165,201 @@ func (r *RelativeValueUnit) SetIDHash() error {
 		return errors.New("EffectiveDate cannot be zero")
 	}
 
-	idh := meta.ToValueMap(*r, "idhash").Hash()
+	vm := meta.ToValueMap(*r, "idhash")
+	// hash the codes as the sql.Null* values they wrap so the hash doesn't change
+	vm["StatusCode"] = r.StatusCode.NullString
+	vm["PCTCIndicator"] = r.PCTCIndicator.NullInt64
+	vm["GlobalSurgeryCode"] = r.GlobalSurgeryCode.NullString
+	vm["MultipleProcedureCode"] = r.MultipleProcedureCode.NullInt64
+	vm["BilateralSurgeryCode"] = r.BilateralSurgeryCode.NullInt64
+	vm["AssistantAtSurgeryCode"] = r.AssistantAtSurgeryCode.NullInt64
+	vm["CoSurgeonsCode"] = r.CoSurgeonsCode.NullInt64
+	vm["TeamSurgeryCode"] = r.TeamSurgeryCode.NullInt64
+	vm["PhysicianSupervisionOfDiagnosticProceduresCode"] = r.PhysicianSupervisionOfDiagnosticProceduresCode.NullString
+	vm["DiagnosticImagingFamilyIndicator"] = r.DiagnosticImagingFamilyIndicator.NullInt64
+	// and the decimals as floats, which is what they were when the hash was defined
+	vm["WRVU"] = r.WRVU.NullFloat64()
+	vm["NonFacilityPERVU"] = r.NonFacilityPERVU.NullFloat64()
+	vm["FacilityPERVU"] = r.FacilityPERVU.NullFloat64()
+	vm["MalpracticeRVU"] = r.MalpracticeRVU.NullFloat64()
+	vm["TotalNonFacilityRVU"] = r.TotalNonFacilityRVU.NullFloat64()
+	vm["TotalFacilityRVU"] = r.TotalFacilityRVU.NullFloat64()
+	vm["ConversionFactor"] = r.ConversionFactor.NullFloat64()
+	vm["NonFacilityPEUsedForOppsPaymentAmount"] = r.NonFacilityPEUsedForOppsPaymentAmount.NullFloat64()
+	vm["FacilityPEUsedForOppsPaymentAmount"] = r.FacilityPEUsedForOppsPaymentAmount.NullFloat64()
+	vm["MalpracticeUsedForOppsPaymentAmount"] = r.MalpracticeUsedForOppsPaymentAmount.NullFloat64()
+	idh := vm.Hash()
 	r.IDHash = idh
 	return nil
 }
 
+// SetNaturalKey sets the natural key from the effective date, hcpcs, modifier and
+// variant, ie 2024-07-01|99213||0. Unlike _id_hash it doesn't change when a correction
+// changes a row's values, so it's what identifies a row across loads of a release. The
+// variant is the row's position among repeats of its hcpcs/modifier, see
+// variantCounter.
+func (r *RelativeValueUnit) SetNaturalKey() error {
+	if r.EffectiveDate.Time.IsZero() {
+		return errors.New("EffectiveDate cannot be zero")
+	}
+	r.NaturalKey = strings.Join([]string{
+		r.EffectiveDate.Time.Format(time.DateOnly),
+		r.HCPCS,
+		r.ModifierCode.String,
+		strconv.FormatInt(r.Variant, 10),
+	}, "|")
+	return nil
+}
+
+// ContentHashVersion identifies the algorithm SetContentHash uses, it's stored with
+// each hash and must change whenever the algorithm does:
+//
+//	sha256/1: the hex sha256 of "<column>=<json value>\n" for each history column
+//	          (the value columns, see historyColumns) in order, values encoded as in
+//	          MarshalJSON
+//	sha256/2: the hex sha256 of "<column>=<value>\n" for each history column in order,
+//	          or "<column>\n" if it's null. Text is quoted as by strconv.Quote, decimals
+//	          are Decimal.String, floats strconv's shortest 'f' format, integers
+//	          base 10 and bools true or false.
+const ContentHashVersion = "sha256/2"
+
+// SetContentHash sets the content hash used for change detection - two rows with the
+// same natural key and content hash have the same values. The encoding is fixed (see
+// ContentHashVersion) rather than borrowed from MarshalJSON, so hashes stay
+// reproducible when the json layout changes.
+func (r *RelativeValueUnit) SetContentHash() error {
+	values := r.contentValues()
+	h := sha256.New()
+	for _, c := range historyColumns {
+		v, ok := values[c]
+		if !ok {
+			return fmt.Errorf("no content hash value for column %s", c)
+		}
+		if v == nil {
+			fmt.Fprintf(h, "%s\n", c)
+			continue
+		}
+		fmt.Fprintf(h, "%s=%s\n", c, *v)
+	}
+	r.ContentHash = fmt.Sprintf("%x", h.Sum(nil))
+	r.HashVersion = ContentHashVersion
+	return nil
+}
+
+// contentValues returns the canonical text of each history column, nil if it's null
+func (r RelativeValueUnit) contentValues() map[string]*string {
+	text := func(s sql.NullString) *string {
+		if !s.Valid {
+			return nil
+		}
+		q := strconv.Quote(s.String)
+		return &q
+	}
+	integer := func(i sql.NullInt64) *string {
+		if !i.Valid {
+			return nil
+		}
+		s := strconv.FormatInt(i.Int64, 10)
+		return &s
+	}
+	float := func(f sql.NullFloat64) *string {
+		if !f.Valid {
+			return nil
+		}
+		s := strconv.FormatFloat(f.Float64, 'f', -1, 64)
+		return &s
+	}
+	boolean := func(b bool) *string {
+		s := strconv.FormatBool(b)
+		return &s
+	}
+	return map[string]*string{
+		"modifier":                  text(r.Modifier),
+		"description":               text(r.Description),
+		"status_code":               text(r.StatusCode.NullString),
+		"status":                    text(r.Status),
+		"wrvu":                      decimalStringPtr(r.WRVU),
+		"nonfacility_pervu":         decimalStringPtr(r.NonFacilityPERVU),
+		"nonfacility_na_indicator":  boolean(r.NonFacilityNAIndicator),
+		"facility_pervu":            decimalStringPtr(r.FacilityPERVU),
+		"facility_na_indicator":     boolean(r.FacilityNAIndicator),
+		"malpractice_rvu":           decimalStringPtr(r.MalpracticeRVU),
+		"total_nonfacility_rvu":     decimalStringPtr(r.TotalNonFacilityRVU),
+		"total_facility_rvu":        decimalStringPtr(r.TotalFacilityRVU),
+		"pctc_indicator":            integer(r.PCTCIndicator.NullInt64),
+		"pctc":                      text(r.PCTC),
+		"global_surgery_code":       text(r.GlobalSurgeryCode.NullString),
+		"global_surgery":            text(r.GlobalSurgery),
+		"preoperative_surgery":      float(r.PreoperativePercentage),
+		"intraoperative_surgery":    float(r.IntraoperativePercentage),
+		"postoperative_surgery":     float(r.PostoperativePercentage),
+		"multiple_procedure_code":   integer(r.MultipleProcedureCode.NullInt64),
+		"multiple_procedure":        text(r.MultipleProcedure),
+		"bilateral_surgery_code":    integer(r.BilateralSurgeryCode.NullInt64),
+		"bilateral_surgery":         text(r.BilateralSurgery),
+		"assistant_at_surgery_code": integer(r.AssistantAtSurgeryCode.NullInt64),
+		"assistant_at_surgery":      text(r.AssistantAtSurgery),
+		"cosurgeons_code":           integer(r.CoSurgeonsCode.NullInt64),
+		"cosurgeons":                text(r.CoSurgeons),
+		"team_surgery_code":         integer(r.TeamSurgeryCode.NullInt64),
+		"team_surgery":              text(r.TeamSurgery),
+		"endoscopic_base_code":      text(r.EndoscopicBaseCode),
+		"conversion_factor":         decimalStringPtr(r.ConversionFactor),
+		"physician_supervision_of_diagnostic_procedures_code": text(r.PhysicianSupervisionOfDiagnosticProceduresCode.NullString),
+		"physician_supervision_of_diagnostic_procedures":      text(r.PhysicianSupervisionOfDiagnosticProcedures),
+		"calculation_flag":                            integer(r.CalculationFlag),
+		"diagnostic_imaging_family_indicator":         integer(r.DiagnosticImagingFamilyIndicator.NullInt64),
+		"diagnostic_imaging_family":                   text(r.DiagnosticImagingFamily),
+		"nonfacility_pe_used_for_opps_payment_amount": decimalStringPtr(r.NonFacilityPEUsedForOppsPaymentAmount),
+		"facility_pe_used_for_opps_payment_amount":    decimalStringPtr(r.FacilityPEUsedForOppsPaymentAmount),
+		"malpractice_used_for_opps_payment_amount":    decimalStringPtr(r.MalpracticeUsedForOppsPaymentAmount),
+	}
+}
+
 func (r *RelativeValueUnit) Process() error {
-	return r.SetIDHash()
+	return errors.Join(r.SetIDHash(), r.SetNaturalKey(), r.SetContentHash())
+}
+
+// variantCounter numbers repeated hcpcs/modifiers within a release in the order
+// they're read. Nothing in a repeated row is stable enough to number it by instead -
+// the description, status and values are what a correction changes - so the variant
+// is the row's order of appearance in the file. If a corrected release reorders two
+// rows of the same hcpcs/modifier they swap natural keys, and show up as two updates
+// rather than none.
+type variantCounter map[rvuKey]int64
+
+// assign sets r's variant and natural key
+func (v variantCounter) assign(r *RelativeValueUnit) error {
+	key := rvuKey{r.HCPCS, r.ModifierCode.String}
+	r.Variant = v[key]
+	v[key]++
+	return r.SetNaturalKey()
+}
+
+// setMissingKeys sets the keys and content hash of rvus read from exports written
+// before they existed, numbering variants within each load of a release
+func (r RelativeValueUnits) setMissingKeys() error {
+	type load struct {
+		effectiveDate, extractTime time.Time
+		source                     string
+	}
+	loads := map[load]variantCounter{}
+	for i := range r {
+		if r[i].NaturalKey != "" {
+			continue
+		}
+		l := load{r[i].EffectiveDate.Time, r[i].ExtractTime, r[i].Source}
+		if loads[l] == nil {
+			loads[l] = variantCounter{}
+		}
+		if err := loads[l].assign(&r[i]); err != nil {
+			return err
+		}
+		if err := r[i].SetContentHash(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func cleanString(s string) string {
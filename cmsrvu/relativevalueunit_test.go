@@ -0,0 +1,79 @@
+package cmsrvu
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"fmt"
+	"testing"
+)
+
+func TestSetContentHash(t *testing.T) {
+	r := RelativeValueUnit{
+		EffectiveDate:          july2024,
+		HCPCS:                  "99213",
+		Description:            sql.NullString{String: "Office o/p est low 20 min", Valid: true},
+		WRVU:                   mustDecimal(t, "1.30"),
+		FacilityNAIndicator:    true,
+		PCTCIndicator:          PCTCIndicator{sql.NullInt64{Valid: true}},
+		PreoperativePercentage: sql.NullFloat64{Valid: true},
+		ConversionFactor:       mustDecimal(t, "33.2875"),
+	}
+	if err := r.SetContentHash(); err != nil {
+		t.Fatal(err)
+	}
+	// the history columns in order, null ones without a value
+	canonical := "modifier\n" +
+		"description=\"Office o/p est low 20 min\"\n" +
+		"status_code\n" +
+		"status\n" +
+		"wrvu=1.30\n" +
+		"nonfacility_pervu\n" +
+		"nonfacility_na_indicator=false\n" +
+		"facility_pervu\n" +
+		"facility_na_indicator=true\n" +
+		"malpractice_rvu\n" +
+		"total_nonfacility_rvu\n" +
+		"total_facility_rvu\n" +
+		"pctc_indicator=0\n" +
+		"pctc\n" +
+		"global_surgery_code\n" +
+		"global_surgery\n" +
+		"preoperative_surgery=0\n" +
+		"intraoperative_surgery\n" +
+		"postoperative_surgery\n" +
+		"multiple_procedure_code\n" +
+		"multiple_procedure\n" +
+		"bilateral_surgery_code\n" +
+		"bilateral_surgery\n" +
+		"assistant_at_surgery_code\n" +
+		"assistant_at_surgery\n" +
+		"cosurgeons_code\n" +
+		"cosurgeons\n" +
+		"team_surgery_code\n" +
+		"team_surgery\n" +
+		"endoscopic_base_code\n" +
+		"conversion_factor=33.2875\n" +
+		"physician_supervision_of_diagnostic_procedures_code\n" +
+		"physician_supervision_of_diagnostic_procedures\n" +
+		"calculation_flag\n" +
+		"diagnostic_imaging_family_indicator\n" +
+		"diagnostic_imaging_family\n" +
+		"nonfacility_pe_used_for_opps_payment_amount\n" +
+		"facility_pe_used_for_opps_payment_amount\n" +
+		"malpractice_used_for_opps_payment_amount\n"
+	want := fmt.Sprintf("%x", sha256.Sum256([]byte(canonical)))
+	if r.HashVersion != ContentHashVersion || r.ContentHash != want {
+		t.Errorf("SetContentHash = %s %s, want %s %s", r.HashVersion, r.ContentHash, ContentHashVersion, want)
+	}
+
+	// a value with the same digits in a different scale is a change, as in the history
+	// table's comparison
+	changed := r
+	changed.WRVU = mustDecimal(t, "1.3")
+	if err := changed.SetContentHash(); err != nil {
+		t.Fatal(err)
+	}
+	if changed.ContentHash == r.ContentHash {
+		t.Error("SetContentHash didn't change with the wrvu's scale")
+	}
+}
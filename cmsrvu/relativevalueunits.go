@@ -5,6 +5,8 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"iter"
+	"net/http"
 	"time"
 
 	"github.com/jackc/pgx/v5/pgtype"
@@ -15,24 +17,45 @@ var DefaultRVUFileRegex = `(?i)^pprrvu.*\.csv$`
 
 type RelativeValueUnits []RelativeValueUnit
 
+// rvuColumns are the db columns of a RelativeValueUnit in table order
+var rvuColumns = append([]string{
+	"_id_hash",
+	"_natural_key",
+	"_variant",
+	"_content_hash",
+	"_hash_version",
+	"_source",
+	"_extract_time",
+	"_last_modified",
+	"_effective_date",
+	"hcpcs",
+	"modifier_code",
+}, historyColumns...)
+
+// GetRVUs downloads and parses a release. cacheFile is ignored, FetchRelease replaces
+// GetRVUs with a context, options and typed meta data.
 func GetRVUs(srcUrl, cacheFile, pattern string, effectiveDate pgtype.Date) (RelativeValueUnits, error) {
+	rvus, _, err := FetchRelease(context.Background(), ReleaseSpec{
+		URL:           srcUrl,
+		EffectiveDate: effectiveDate,
+		FileRegex:     pattern,
+	})
+	return rvus, err
+}
+
+// RVUsFromRecords converts the records and meta data returned by GetRecords (or
+// RecordsFromZip) to RelativeValueUnits. Records without a status code (blank lines,
+// footnotes, etc) are skipped.
+func RVUsFromRecords(records [][]string, md map[string]any, effectiveDate pgtype.Date) (RelativeValueUnits, error) {
 	if !effectiveDate.Valid {
 		return nil, errors.New("valid effectiveDate required")
 	}
 
-	if pattern == "" {
-		pattern = DefaultRVUFileRegex
-	}
-
-	records, md, err := GetRecords(srcUrl, cacheFile, pattern)
-	if err != nil {
-		return nil, err
-	}
-
 	source := (md["source"]).(string)
 	lastModified := (md["last-modified"]).(time.Time)
 	extractTime := (md["extract-time"]).(time.Time)
 	rvus := []RelativeValueUnit{}
+	variants := variantCounter{}
 
 	// switch {
 	// case len(records) >= 11:
@@ -42,38 +65,121 @@ func GetRVUs(srcUrl, cacheFile, pattern string, effectiveDate pgtype.Date) (Rela
 	// }
 
 	for _, r := range records {
-		rvu, err := RVUFromRecord(r)
-		if !rvu.StatusCode.Valid {
-			continue
-		}
-		rvu.Source = source
-		rvu.ExtractTime = extractTime
-		rvu.LastModified = lastModified
-		rvu.EffectiveDate = effectiveDate
+		rvu, ok, err := rvuFromRecord(r, source, extractTime, lastModified, effectiveDate, variants)
 		if err != nil {
 			return nil, err
 		}
-		if err := rvu.Process(); err != nil {
-			return nil, err
+		if ok {
+			rvus = append(rvus, rvu)
 		}
-		rvus = append(rvus, rvu)
 	}
 
 	return rvus, nil
 }
 
+// rvuFromRecord converts a record to a RelativeValueUnit, ok is false for records
+// without a status code (blank lines, footnotes, etc). variants numbers repeated
+// hcpcs/modifiers and should be shared by every record in the release.
+func rvuFromRecord(record []string, source string, extractTime, lastModified time.Time, effectiveDate pgtype.Date, variants variantCounter) (rvu RelativeValueUnit, ok bool, err error) {
+	rvu, err = RVUFromRecord(record)
+	if !rvu.StatusCode.Valid {
+		return rvu, false, nil
+	}
+	rvu.Source = source
+	rvu.ExtractTime = extractTime
+	rvu.LastModified = lastModified
+	rvu.EffectiveDate = effectiveDate
+	if err != nil {
+		return rvu, false, err
+	}
+	if err := rvu.Process(); err != nil {
+		return rvu, false, err
+	}
+	if err := variants.assign(&rvu); err != nil {
+		return rvu, false, err
+	}
+	return rvu, true, nil
+}
+
+// IterRVUs downloads the archive at srcUrl and yields rvus as they are parsed from the
+// file matching pattern, rather than returning them all at once like GetRVUs. The
+// archive itself is held in memory, but the rvus aren't, so callers can filter and
+// sink them with constant memory or stop early. Parsing stops at the first error.
+func IterRVUs(srcUrl, pattern string, effectiveDate pgtype.Date) iter.Seq2[RelativeValueUnit, error] {
+	return IterRelease(context.Background(), ReleaseSpec{
+		URL:           srcUrl,
+		EffectiveDate: effectiveDate,
+		FileRegex:     pattern,
+	})
+}
+
+// IterRVUsFromZip is IterRVUs once the archive has been downloaded, see RecordsFromZip.
+// It parses the same way as IterRelease, and takes its WithFileRegex, WithParserMode
+// and WithLogger options - pattern is the same as WithFileRegex. There's nothing to
+// wait on, so no context: stop ranging over it to stop parsing.
+func IterRVUsFromZip(zippedData []byte, headers http.Header, srcUrl, pattern string, effectiveDate pgtype.Date, opts ...Option) iter.Seq2[RelativeValueUnit, error] {
+	return func(yield func(RelativeValueUnit, error) bool) {
+		if !effectiveDate.Valid {
+			yield(RelativeValueUnit{}, errors.New("valid effectiveDate required"))
+			return
+		}
+		spec := ReleaseSpec{URL: srcUrl, EffectiveDate: effectiveDate, FileRegex: pattern}
+		md := ReleaseMetadata{Source: srcUrl, EffectiveDate: effectiveDate}
+		if err := md.setFromResponse(zippedData, headers); err != nil {
+			yield(RelativeValueUnit{}, err)
+			return
+		}
+		parseRelease(context.Background(), zippedData, md, newFetchOptions(spec, opts), yield)
+	}
+}
+
 func (r RelativeValueUnits) CreatePostgresTable(ctx context.Context, db *sqlx.DB, schema, table string) (sql.Result, error) {
+	return r.createPostgresTable(ctx, db, schema, table, false)
+}
 
-	if schema == "" {
-		schema = "cmsrvu"
+// CreatePartitionedPostgresTable creates the same table as CreatePostgresTable, but
+// range partitioned on _effective_date with one partition per year. Partitions are
+// created as releases load (see EnsurePostgresPartition), and the primary key becomes
+// (_id_hash, _effective_date) since postgres requires it to include the partition key.
+// A table can't be converted either way once it exists, so both return an error if the
+// table exists and its partitioning doesn't match.
+func (r RelativeValueUnits) CreatePartitionedPostgresTable(ctx context.Context, db *sqlx.DB, schema, table string) (sql.Result, error) {
+	return r.createPostgresTable(ctx, db, schema, table, true)
+}
+
+func (r RelativeValueUnits) createPostgresTable(ctx context.Context, db *sqlx.DB, schema, table string, partitioned bool) (sql.Result, error) {
+
+	names, err := newTableNames(schema, table)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.ExecContext(ctx, "create schema if not exists "+names.quotedSchema()); err != nil {
+		return nil, err
 	}
-	_, err := db.ExecContext(ctx, fmt.Sprintf("create schema if not exists %s", schema))
+	exists, isPartitioned, err := postgresTablePartitioned(ctx, db, names)
 	if err != nil {
 		return nil, err
 	}
+	if exists && isPartitioned != partitioned {
+		if isPartitioned {
+			return nil, fmt.Errorf("%s is partitioned, it has to be created as a partitioned table", names.qualified(""))
+		}
+		return nil, fmt.Errorf("%s already exists and isn't partitioned, it can't be converted", names.qualified(""))
+	}
+	// partitioned tables need the partition key in the primary key
+	pk, pkConstraint, partition := "primary key", "", ""
+	if partitioned {
+		pk = ""
+		pkConstraint = ",\n\t\tprimary key (_id_hash, _effective_date)"
+		partition = "partition by range (_effective_date)"
+	}
 	q := `
-	create table if not exists %s.%s (
-		_id_hash text primary key,
+	create table if not exists %[1]s (
+		_id_hash text %[2]s,
+		_natural_key text,
+		_variant int,
+		_content_hash text,
+		_hash_version text,
 		_source text,         
 		_extract_time timestamptz,   
 		_last_modified timestamptz,  
@@ -118,15 +224,87 @@ func (r RelativeValueUnits) CreatePostgresTable(ctx context.Context, db *sqlx.DB
 		diagnostic_imaging_family text,
 		nonfacility_pe_used_for_opps_payment_amount numeric,
 		facility_pe_used_for_opps_payment_amount numeric,
-		malpractice_used_for_opps_payment_amount numeric
-	)`
-	return db.ExecContext(ctx, fmt.Sprintf(q, schema, table))
+		malpractice_used_for_opps_payment_amount numeric%[3]s
+	) %[4]s`
+	if _, err := db.ExecContext(ctx, fmt.Sprintf(q, names.qualified(""), pk, pkConstraint, partition)); err != nil {
+		return nil, err
+	}
+
+	if err := migratePostgresTable(ctx, db, names); err != nil {
+		return nil, err
+	}
+
+	// on a partitioned table this creates a matching index on every partition
+	q = `create index if not exists %s on %s (hcpcs, modifier_code, _effective_date)`
+	return db.ExecContext(ctx, fmt.Sprintf(q, names.name("_hcpcs_idx"), names.qualified("")))
+}
+
+// migratePostgresTable adds the natural key and content hash columns to tables created
+// before they existed, along with the unique index on the natural key (which includes
+// the effective date so it works on partitioned tables). Rows loaded before the
+// migration have null keys until their release is reloaded with UpsertPostgres. The
+// key's variant is the order repeated hcpcs/modifiers appear in the file, so the index
+// only identifies them as well as that order is stable - see variantCounter.
+func migratePostgresTable(ctx context.Context, db *sqlx.DB, names tableNames) error {
+	q := `
+	alter table %[1]s
+		add column if not exists _natural_key text,
+		add column if not exists _variant int,
+		add column if not exists _content_hash text,
+		add column if not exists _hash_version text;
+	create unique index if not exists %[2]s on %[1]s (_natural_key, _effective_date)`
+	_, err := db.ExecContext(ctx, fmt.Sprintf(q, names.qualified(""), names.name("_natural_key_idx")))
+	return err
+}
+
+// postgresTablePartitioned reports whether table exists and whether it's partitioned
+func postgresTablePartitioned(ctx context.Context, db *sqlx.DB, names tableNames) (exists, partitioned bool, err error) {
+	q := `
+	select
+		to_regclass($1) is not null,
+		exists (select 1 from pg_partitioned_table where partrelid = to_regclass($1))`
+	err = db.QueryRowContext(ctx, q, names.qualified("")).Scan(&exists, &partitioned)
+	return exists, partitioned, err
+}
+
+// EnsurePostgresPartition creates the yearly partition of table that holds
+// effectiveDate, ie rvu_2024, if table is partitioned and the partition doesn't exist.
+// It does nothing for tables created by CreatePostgresTable.
+func (r RelativeValueUnits) EnsurePostgresPartition(ctx context.Context, db *sqlx.DB, schema, table string, effectiveDate pgtype.Date) error {
+	names, err := newTableNames(schema, table)
+	if err != nil {
+		return err
+	}
+	if _, partitioned, err := postgresTablePartitioned(ctx, db, names); err != nil || !partitioned {
+		return err
+	}
+
+	year := effectiveDate.Time.Year()
+	q := `
+	create table if not exists %[1]s partition of %[2]s
+	for values from ('%[3]d-01-01') to ('%[4]d-01-01')`
+	_, err = db.ExecContext(ctx, fmt.Sprintf(q, names.qualified(fmt.Sprintf("_%04d", year)), names.qualified(""), year, year+1))
+	return err
 }
 
 func (r RelativeValueUnits) PutPostgres(db *sqlx.DB, schema, table string) (sql.Result, error) {
+	return r.putPostgres(context.Background(), db, schema, table)
+}
+
+// putPostgres does the actual insert for PutPostgres, it accepts anything that can
+// execute queries so it can also be used inside a transaction
+func (r RelativeValueUnits) putPostgres(ctx context.Context, e sqlx.ExtContext, schema, table string) (sql.Result, error) {
+	names, err := newTableNames(schema, table)
+	if err != nil {
+		return nil, err
+	}
 	q := `
-	insert into %s.%s (
+	insert into %s (
 	_id_hash,
+	_natural_key,
+	_variant,
+	_content_hash,
+	_hash_version,
 	_source,         
 	_extract_time,   
 	_last_modified,  
@@ -174,6 +352,10 @@ func (r RelativeValueUnits) PutPostgres(db *sqlx.DB, schema, table string) (sql.
 	malpractice_used_for_opps_payment_amount
 	) values (
 	:_id_hash,
+	:_natural_key,
+	:_variant,
+	:_content_hash,
+	:_hash_version,
 	:_source,         
 	:_extract_time,   
 	:_last_modified,  
@@ -219,8 +401,8 @@ func (r RelativeValueUnits) PutPostgres(db *sqlx.DB, schema, table string) (sql.
 	:nonfacility_pe_used_for_opps_payment_amount,
 	:facility_pe_used_for_opps_payment_amount,
 	:malpractice_used_for_opps_payment_amount
-	) on conflict (_id_hash) do nothing
+	) on conflict do nothing
 	`
-	return db.NamedExec(fmt.Sprintf(q, schema, table), r)
+	return sqlx.NamedExecContext(ctx, e, fmt.Sprintf(q, names.qualified("")), r)
 
 }
@@ -0,0 +1,97 @@
+package cmsrvu
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// DeleteRelease removes the release effective on effectiveDate from Table and the
+// reference tables (ie GPCITable), or only the rows loaded from source if it isn't
+// empty, and rebuilds the history table. A deleted row is added to the load log for
+// every source removed with its row count (see LoadLog.RowsDeleted), so the next Load
+// will load the release again - or one for source with a count of 0 if nothing
+// matched, so every attempt is logged. Everything happens in a single transaction, the
+// number of rows deleted is returned.
+func (l Loader) DeleteRelease(ctx context.Context, effectiveDate pgtype.Date, source string) (int64, error) {
+	names, err := l.tableNames()
+	if err != nil {
+		return 0, err
+	}
+	tx, err := l.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	date := effectiveDate.Time.Format(time.DateOnly)
+	where := `where _effective_date = ? and (cast(? as text) = '' or _source = ?)`
+
+	// the rows each source loses, or source with none if nothing matches
+	type sourceRows struct {
+		Source string `db:"source"`
+		Rows   int64  `db:"rows"`
+	}
+	sources := []sourceRows{}
+	q := fmt.Sprintf(
+		"select coalesce(_source, '') as source, count(*) as rows from %s %s group by 1",
+		names.qualified(""), where,
+	)
+	if err := tx.SelectContext(ctx, &sources, tx.Rebind(q), date, source, source); err != nil {
+		return 0, err
+	}
+	if len(sources) == 0 {
+		sources = append(sources, sourceRows{Source: source})
+	}
+
+	q = fmt.Sprintf("delete from %s %s", names.qualified(""), where)
+	res, err := tx.ExecContext(ctx, tx.Rebind(q), date, source, source)
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, f := range l.referenceFiles() {
+		ref, err := dbTableNames(l.DB, l.Schema, f.table)
+		if err != nil {
+			return 0, err
+		}
+		if err := deleteReferenceRows(ctx, tx, ref, effectiveDate, source); err != nil {
+			return 0, err
+		}
+	}
+
+	if !l.sqlite() {
+		if err := rebuildPostgresHistory(ctx, tx, l.Schema, l.Table); err != nil {
+			return 0, err
+		}
+	}
+
+	now := time.Now().UTC()
+	q = fmt.Sprintf(`
+	insert into %s (source, effective_date, rows_deleted, start_time, end_time, status)
+	values (?, ?, ?, ?, ?, ?)`, l.loadLogTable())
+	for _, src := range sources {
+		if _, err := tx.ExecContext(ctx, tx.Rebind(q), src.Source, date, src.Rows, now, now, LoadStatusDeleted); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	if l.sqlite() {
+		return deleted, nil
+	}
+	return deleted, RelativeValueUnits{}.RefreshPostgresViews(ctx, l.DB, l.Schema, l.Table)
+}
+
+// tableNames validates Schema and Table
+func (l Loader) tableNames() (tableNames, error) {
+	return dbTableNames(l.DB, l.Schema, l.Table)
+}
@@ -0,0 +1,323 @@
+package cmsrvu
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Sink is a destination for parsed releases. A release is written by calling
+// BeginRelease, WriteBatch any number of times and then Commit - or Abort if anything
+// goes wrong. Sinks are not safe for concurrent use, use one per goroutine.
+type Sink interface {
+	// CreateSchema creates whatever tables/directories the sink needs, it should be
+	// safe to call more than once
+	CreateSchema(ctx context.Context) error
+	BeginRelease(ctx context.Context, release DataConfig) error
+	WriteBatch(ctx context.Context, rvus RelativeValueUnits) error
+	Commit(ctx context.Context) error
+	Abort(ctx context.Context) error
+}
+
+// sinkBatchSize is the number of rows WriteRelease passes to WriteBatch at a time
+const sinkBatchSize = postgresBatchSize
+
+// WriteRelease writes rvus to sink as a single release, aborting if any step fails
+func WriteRelease(ctx context.Context, sink Sink, release DataConfig, rvus RelativeValueUnits) error {
+	if err := sink.BeginRelease(ctx, release); err != nil {
+		return err
+	}
+	for i := 0; i < len(rvus); i += sinkBatchSize {
+		j := min(i+sinkBatchSize, len(rvus))
+		if err := sink.WriteBatch(ctx, rvus[i:j]); err != nil {
+			return errors.Join(err, sink.Abort(ctx))
+		}
+	}
+	if err := sink.Commit(ctx); err != nil {
+		return errors.Join(err, sink.Abort(ctx))
+	}
+	return nil
+}
+
+// MultiSink writes to several sinks at once. Sinks are committed in order, so if one
+// fails to commit the sinks before it will already have committed.
+type MultiSink []Sink
+
+func (m MultiSink) CreateSchema(ctx context.Context) error {
+	for _, s := range m {
+		if err := s.CreateSchema(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m MultiSink) BeginRelease(ctx context.Context, release DataConfig) error {
+	for i, s := range m {
+		if err := s.BeginRelease(ctx, release); err != nil {
+			return errors.Join(err, m[:i].Abort(ctx))
+		}
+	}
+	return nil
+}
+
+func (m MultiSink) WriteBatch(ctx context.Context, rvus RelativeValueUnits) error {
+	for _, s := range m {
+		if err := s.WriteBatch(ctx, rvus); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m MultiSink) Commit(ctx context.Context) error {
+	for _, s := range m {
+		if err := s.Commit(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m MultiSink) Abort(ctx context.Context) error {
+	errs := []error{}
+	for _, s := range m {
+		errs = append(errs, s.Abort(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+// MemorySink keeps committed releases in memory, it's mostly useful for tests and for
+// building lookups (see RVUs)
+type MemorySink struct {
+	mu       sync.RWMutex
+	releases map[DataConfig]RelativeValueUnits
+	order    []DataConfig
+	release  DataConfig
+	staged   RelativeValueUnits
+}
+
+func (m *MemorySink) CreateSchema(ctx context.Context) error {
+	return nil
+}
+
+func (m *MemorySink) BeginRelease(ctx context.Context, release DataConfig) error {
+	m.release = release
+	m.staged = nil
+	return nil
+}
+
+func (m *MemorySink) WriteBatch(ctx context.Context, rvus RelativeValueUnits) error {
+	m.staged = append(m.staged, rvus...)
+	return nil
+}
+
+// Commit replaces any earlier copy of the release
+func (m *MemorySink) Commit(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.releases == nil {
+		m.releases = map[DataConfig]RelativeValueUnits{}
+	}
+	if _, ok := m.releases[m.release]; !ok {
+		m.order = append(m.order, m.release)
+	}
+	m.releases[m.release] = m.staged
+	m.staged = nil
+	return nil
+}
+
+func (m *MemorySink) Abort(ctx context.Context) error {
+	m.staged = nil
+	return nil
+}
+
+// RVUs returns every committed release in the order they were first committed
+func (m *MemorySink) RVUs() RelativeValueUnits {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := RelativeValueUnits{}
+	for _, release := range m.order {
+		out = append(out, m.releases[release]...)
+	}
+	return out
+}
+
+// PostgresSink writes releases to Schema.Table. In LoadModeInsert each release is
+// written in a single transaction, in LoadModeUpsert batches are held until Commit
+// (see UpsertPostgres). Committing also updates the history table and refreshes the
+// views (see CreatePostgresViews) in the release's transaction, so a release is loaded
+// along with its history or not at all. If Partitioned is set the table is partitioned by
+// year, see CreatePartitionedPostgresTable.
+type PostgresSink struct {
+	DB          *sqlx.DB
+	Schema      string
+	Table       string
+	Mode        LoadMode
+	Partitioned bool
+
+	// Inserted is the number of rows inserted (or updated, for upserts) by the
+	// current release
+	Inserted int64
+
+	release DataConfig
+	tx      *sqlx.Tx
+	staged  RelativeValueUnits
+}
+
+func (p *PostgresSink) CreateSchema(ctx context.Context) error {
+	rvus := RelativeValueUnits{}
+	create := rvus.CreatePostgresTable
+	if p.Partitioned {
+		create = rvus.CreatePartitionedPostgresTable
+	}
+	if _, err := create(ctx, p.DB, p.Schema, p.Table); err != nil {
+		return err
+	}
+	if _, err := rvus.CreatePostgresHistoryTable(ctx, p.DB, p.Schema, p.Table); err != nil {
+		return err
+	}
+	if _, err := rvus.CreatePostgresChangeLogTable(ctx, p.DB, p.Schema, p.Table); err != nil {
+		return err
+	}
+	return rvus.CreatePostgresViews(ctx, p.DB, p.Schema, p.Table)
+}
+
+func (p *PostgresSink) BeginRelease(ctx context.Context, release DataConfig) error {
+	p.release = release
+	p.Inserted = 0
+	p.staged = nil
+	err := RelativeValueUnits{}.EnsurePostgresPartition(ctx, p.DB, p.Schema, p.Table, release.EffectiveDate)
+	if err != nil {
+		return err
+	}
+	if p.Mode == LoadModeUpsert {
+		return nil
+	}
+	tx, err := p.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	p.tx = tx
+	return nil
+}
+
+func (p *PostgresSink) WriteBatch(ctx context.Context, rvus RelativeValueUnits) error {
+	if p.Mode == LoadModeUpsert {
+		// upserts need the whole release at once to find rows that were dropped
+		p.staged = append(p.staged, rvus...)
+		return nil
+	}
+	for i := 0; i < len(rvus); i += postgresBatchSize {
+		j := min(i+postgresBatchSize, len(rvus))
+		res, err := rvus[i:j].putPostgres(ctx, p.tx, p.Schema, p.Table)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		p.Inserted += n
+	}
+	return nil
+}
+
+func (p *PostgresSink) Commit(ctx context.Context) error {
+	if p.Mode == LoadModeUpsert {
+		tx, err := p.DB.BeginTxx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		p.tx = tx
+		summary, err := p.staged.upsertPostgres(ctx, p.tx, p.Schema, p.Table)
+		if err != nil {
+			return err
+		}
+		p.Inserted = summary.Inserted + summary.Updated
+	}
+	// the history and views are updated in the same transaction as the rows, so if
+	// either fails the release isn't loaded and Abort rolls everything back
+	if err := updatePostgresHistory(ctx, p.tx, p.Schema, p.Table, p.release.EffectiveDate); err != nil {
+		return err
+	}
+	if err := refreshPostgresViews(ctx, p.tx, p.Schema, p.Table); err != nil {
+		return err
+	}
+	if err := p.tx.Commit(); err != nil {
+		return err
+	}
+	p.tx = nil
+	p.staged = nil
+	return nil
+}
+
+func (p *PostgresSink) Abort(ctx context.Context) error {
+	p.staged = nil
+	if p.tx == nil {
+		return nil
+	}
+	err := p.tx.Rollback()
+	p.tx = nil
+	if errors.Is(err, sql.ErrTxDone) {
+		return nil
+	}
+	return err
+}
+
+// SQLiteSink writes releases to Table in a sqlite database, each release is written in
+// a single transaction. CreateSchema creates the table and its views (see
+// CreateSQLiteViews). Only LoadModeInsert is supported.
+type SQLiteSink struct {
+	DB    *sqlx.DB
+	Table string
+
+	// Inserted is the number of rows inserted by the current release
+	Inserted int64
+
+	tx *sqlx.Tx
+}
+
+func (s *SQLiteSink) CreateSchema(ctx context.Context) error {
+	if _, err := (RelativeValueUnits{}).CreateSQLiteTable(ctx, s.DB, s.Table); err != nil {
+		return err
+	}
+	return RelativeValueUnits{}.CreateSQLiteViews(ctx, s.DB, s.Table)
+}
+
+func (s *SQLiteSink) BeginRelease(ctx context.Context, release DataConfig) error {
+	s.Inserted = 0
+	tx, err := s.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	s.tx = tx
+	return nil
+}
+
+func (s *SQLiteSink) WriteBatch(ctx context.Context, rvus RelativeValueUnits) error {
+	n, err := rvus.putSQLite(ctx, s.tx, s.Table)
+	s.Inserted += n
+	return err
+}
+
+func (s *SQLiteSink) Commit(ctx context.Context) error {
+	err := s.tx.Commit()
+	s.tx = nil
+	return err
+}
+
+func (s *SQLiteSink) Abort(ctx context.Context) error {
+	if s.tx == nil {
+		return nil
+	}
+	err := s.tx.Rollback()
+	s.tx = nil
+	if errors.Is(err, sql.ErrTxDone) {
+		return nil
+	}
+	return err
+}
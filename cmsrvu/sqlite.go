@@ -0,0 +1,236 @@
+package cmsrvu
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// CreateSQLiteTable is the sqlite equivalent of CreatePostgresTable. Sqlite doesn't
+// have schemas, so there's no schema argument. Dates are stored as yyyy-mm-dd text so
+// they compare correctly, and decimals as text so they're exact - sqlite would store
+// numeric columns as reals. Tables created before that keep their numeric columns,
+// which Decimal.Scan reads through DecimalFromFloat.
+func (r RelativeValueUnits) CreateSQLiteTable(ctx context.Context, db *sqlx.DB, table string) (sql.Result, error) {
+	names, err := newSQLiteTableNames(table)
+	if err != nil {
+		return nil, err
+	}
+	q := `
+	create table if not exists %[1]s (
+		_id_hash text primary key,
+		_natural_key text,
+		_variant int,
+		_content_hash text,
+		_hash_version text,
+		_source text,
+		_extract_time timestamp,
+		_last_modified timestamp,
+		_effective_date date,
+		hcpcs text,
+		modifier_code text,
+		modifier text,
+		description text,
+		status_code text,
+		status text,
+		wrvu text,
+		nonfacility_pervu text,
+		nonfacility_na_indicator boolean,
+		facility_pervu text,
+		facility_na_indicator boolean,
+		malpractice_rvu text,
+		total_nonfacility_rvu text,
+		total_facility_rvu text,
+		pctc_indicator int,
+		pctc text,
+		global_surgery_code text,
+		global_surgery text,
+		preoperative_surgery numeric,
+		intraoperative_surgery numeric,
+		postoperative_surgery numeric,
+		multiple_procedure_code int,
+		multiple_procedure text,
+		bilateral_surgery_code int,
+		bilateral_surgery text,
+		assistant_at_surgery_code int,
+		assistant_at_surgery text,
+		cosurgeons_code int,
+		cosurgeons text,
+		team_surgery_code int,
+		team_surgery text,
+		endoscopic_base_code text,
+		conversion_factor text,
+		physician_supervision_of_diagnostic_procedures_code text,
+		physician_supervision_of_diagnostic_procedures text,
+		calculation_flag int,
+		diagnostic_imaging_family_indicator int,
+		diagnostic_imaging_family text,
+		nonfacility_pe_used_for_opps_payment_amount text,
+		facility_pe_used_for_opps_payment_amount text,
+		malpractice_used_for_opps_payment_amount text
+	);
+	create index if not exists %[2]s on %[1]s (hcpcs, modifier_code, _effective_date)`
+	res, err := db.ExecContext(ctx, fmt.Sprintf(q, names.qualified(""), names.name("_hcpcs_idx")))
+	if err != nil {
+		return nil, err
+	}
+	if err := migrateSQLiteTable(ctx, db, names); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// migrateSQLiteTable is the sqlite equivalent of migratePostgresTable. Sqlite can't add
+// a column if it doesn't exist, so the existing columns are checked first. As in
+// postgres, the variant in the unique natural key is the order repeated
+// hcpcs/modifiers appear in the file, see variantCounter.
+func migrateSQLiteTable(ctx context.Context, db *sqlx.DB, names tableNames) error {
+	existing := []string{}
+	q := "select name from pragma_table_info(?)"
+	if err := db.SelectContext(ctx, &existing, q, names.table); err != nil {
+		return err
+	}
+	for _, c := range []struct{ name, typ string }{
+		{"_natural_key", "text"},
+		{"_variant", "int"},
+		{"_content_hash", "text"},
+		{"_hash_version", "text"},
+	} {
+		if slices.Contains(existing, c.name) {
+			continue
+		}
+		q := fmt.Sprintf("alter table %s add column %s %s", names.qualified(""), c.name, c.typ)
+		if _, err := db.ExecContext(ctx, q); err != nil {
+			return err
+		}
+	}
+	// the same key as postgres, tables created when it was only on _natural_key have
+	// it replaced
+	var stale bool
+	q = "select exists (select 1 from sqlite_master where type = 'index' and name = ? and sql not like '%_effective_date%')"
+	if err := db.QueryRowContext(ctx, q, names.table+"_natural_key_idx").Scan(&stale); err != nil {
+		return err
+	}
+	if stale {
+		if _, err := db.ExecContext(ctx, "drop index "+names.name("_natural_key_idx")); err != nil {
+			return err
+		}
+	}
+	q = fmt.Sprintf("create unique index if not exists %s on %s (_natural_key, _effective_date)", names.name("_natural_key_idx"), names.qualified(""))
+	_, err := db.ExecContext(ctx, q)
+	return err
+}
+
+// PutSQLite is the sqlite equivalent of PutPostgres, rows that are already present
+// are ignored. Rows are inserted one at a time with a prepared statement inside a
+// single transaction, which is faster in sqlite than multi-row inserts.
+func (r RelativeValueUnits) PutSQLite(ctx context.Context, db *sqlx.DB, table string) (sql.Result, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	n, err := r.putSQLite(ctx, tx, table)
+	if err != nil {
+		return nil, err
+	}
+	return rowsAffected(n), tx.Commit()
+}
+
+// putSQLite inserts r inside tx and returns the number of rows inserted
+func (r RelativeValueUnits) putSQLite(ctx context.Context, tx *sqlx.Tx, table string) (int64, error) {
+	names, err := newSQLiteTableNames(table)
+	if err != nil {
+		return 0, err
+	}
+	values := make([]string, len(rvuColumns))
+	for i, c := range rvuColumns {
+		values[i] = ":" + c
+		if c == "_effective_date" {
+			values[i] = "date(:_effective_date)"
+		}
+	}
+	q := fmt.Sprintf(
+		"insert or ignore into %s (%s) values (%s)",
+		names.qualified(""), strings.Join(rvuColumns, ", "), strings.Join(values, ", "),
+	)
+
+	stmt, err := tx.PrepareNamedContext(ctx, q)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	var n int64
+	for _, rvu := range r {
+		res, err := stmt.ExecContext(ctx, rvu)
+		if err != nil {
+			return n, err
+		}
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return n, err
+		}
+		n += rows
+	}
+	return n, nil
+}
+
+// CreateSQLiteLoadLogTable is the sqlite equivalent of CreatePostgresLoadLogTable
+func CreateSQLiteLoadLogTable(ctx context.Context, db *sqlx.DB, table string) (sql.Result, error) {
+	name, err := loadLogName("", table)
+	if err != nil {
+		return nil, err
+	}
+	q := `
+	create table if not exists %s (
+		load_id integer primary key autoincrement,
+		source text not null,
+		effective_date date not null,
+		checksum text,
+		rows_parsed bigint not null default 0,
+		rows_inserted bigint not null default 0,
+		rows_rejected bigint not null default 0,
+		rows_deleted bigint not null default 0,
+		start_time timestamp not null,
+		end_time timestamp,
+		status text not null,
+		error text
+	)`
+	res, err := db.ExecContext(ctx, fmt.Sprintf(q, name))
+	if err != nil {
+		return nil, err
+	}
+	// load logs created before rows_deleted existed
+	if table == "" {
+		table = "load_log"
+	}
+	var exists bool
+	q = "select exists (select 1 from pragma_table_info(?) where name = 'rows_deleted')"
+	if err := db.QueryRowContext(ctx, q, table).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		q = "alter table %s add column rows_deleted bigint not null default 0"
+		if _, err := db.ExecContext(ctx, fmt.Sprintf(q, name)); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// rowsAffected is a sql.Result for totals accumulated over several statements
+type rowsAffected int64
+
+func (r rowsAffected) LastInsertId() (int64, error) {
+	return 0, fmt.Errorf("LastInsertId is not supported")
+}
+
+func (r rowsAffected) RowsAffected() (int64, error) {
+	return int64(r), nil
+}
@@ -0,0 +1,177 @@
+package cmsrvu
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// LoadMode determines how a release is written to the database
+type LoadMode string
+
+const (
+	// LoadModeInsert only inserts rows that aren't already present (by _natural_key),
+	// rows from an earlier posting of the same release are left in place
+	LoadModeInsert LoadMode = "insert"
+	// LoadModeUpsert treats the incoming rows as the complete release - changed rows are
+	// replaced, rows missing from the release are deleted and every change is logged
+	LoadModeUpsert LoadMode = "upsert"
+)
+
+// ChangeSummary counts the changes made to a table by UpsertPostgres
+type ChangeSummary struct {
+	Inserted int64
+	Updated  int64
+	Deleted  int64
+}
+
+// unchangedRow matches a row in the table (o) to the same, unchanged row in the
+// release (n)
+const unchangedRow = `n._natural_key = o._natural_key
+		and n._content_hash = o._content_hash
+		and n._hash_version = o._hash_version`
+
+// postgresBatchSize keeps bulk inserts comfortably under postgres' parameter limit
+const postgresBatchSize = 1000
+
+// CreatePostgresChangeLogTable creates the change log used by UpsertPostgres, it is
+// named after the table it tracks, ie rvu -> rvu_change_log
+func (r RelativeValueUnits) CreatePostgresChangeLogTable(ctx context.Context, db *sqlx.DB, schema, table string) (sql.Result, error) {
+	names, err := newTableNames(schema, table)
+	if err != nil {
+		return nil, err
+	}
+	q := `
+	create table if not exists %s (
+		change_id bigserial primary key,
+		change_time timestamptz not null default now(),
+		operation text not null,
+		_source text,
+		_effective_date date,
+		hcpcs text,
+		modifier_code text,
+		before jsonb,
+		after jsonb
+	)`
+	return db.ExecContext(ctx, fmt.Sprintf(q, names.qualified("_change_log")))
+}
+
+// UpsertPostgres loads r as the complete contents of its release(s), keyed on
+// _natural_key and compared by _content_hash:
+//   - rows that changed are replaced
+//   - rows that are no longer in the release are deleted
+//   - new rows are inserted
+//
+// Before and after images of every change are written to the table's change log
+// (see CreatePostgresChangeLogTable). Everything happens in a single transaction.
+// Rows hashed with a different ContentHashVersion count as updated, so they're
+// rewritten with the current one.
+func (r RelativeValueUnits) UpsertPostgres(ctx context.Context, db *sqlx.DB, schema, table string) (ChangeSummary, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return ChangeSummary{}, err
+	}
+	defer tx.Rollback()
+
+	summary, err := r.upsertPostgres(ctx, tx, schema, table)
+	if err != nil {
+		return summary, err
+	}
+	return summary, tx.Commit()
+}
+
+// upsertPostgres is UpsertPostgres in tx, so the history can be updated in the same
+// transaction
+func (r RelativeValueUnits) upsertPostgres(ctx context.Context, tx *sqlx.Tx, schema, table string) (ChangeSummary, error) {
+	summary := ChangeSummary{}
+	names, err := newTableNames(schema, table)
+	if err != nil {
+		return summary, err
+	}
+
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(
+		"create temp table cmsrvu_stage (like %s including defaults) on commit drop",
+		names.qualified(""),
+	)); err != nil {
+		return summary, err
+	}
+	for i := 0; i < len(r); i += postgresBatchSize {
+		j := min(i+postgresBatchSize, len(r))
+		if _, err := r[i:j].putPostgres(ctx, tx, "pg_temp", "cmsrvu_stage"); err != nil {
+			return summary, err
+		}
+	}
+
+	// stale rows are in the table but not the release, fresh rows are the reverse -
+	// pairing them up on the natural key tells us what was inserted/updated/deleted.
+	// Rows loaded before the natural key existed don't have one, so they're paired on
+	// its parts.
+	q := `
+	with stale as (
+		select o.* from %[1]s o
+		where o._effective_date in (select distinct _effective_date from cmsrvu_stage)
+		and not exists (select 1 from cmsrvu_stage n where %[3]s)
+	), fresh as (
+		select n.* from cmsrvu_stage n
+		where not exists (select 1 from %[1]s o where %[3]s)
+	), logged as (
+		insert into %[2]s (operation, _source, _effective_date, hcpcs, modifier_code, before, after)
+		select
+			case
+				when n._id_hash is null then 'delete'
+				when o._id_hash is null then 'insert'
+				else 'update'
+			end,
+			coalesce(n._source, o._source),
+			coalesce(n._effective_date, o._effective_date),
+			coalesce(n.hcpcs, o.hcpcs),
+			coalesce(n.modifier_code, o.modifier_code),
+			case when o._id_hash is null then null else to_jsonb(o) end,
+			case when n._id_hash is null then null else to_jsonb(n) end
+		from stale o
+		full join fresh n
+			on n._effective_date = o._effective_date
+			and n.hcpcs = o.hcpcs
+			and n.modifier_code is not distinct from o.modifier_code
+			and n._variant = coalesce(o._variant, 0)
+		returning operation
+	)
+	select operation, count(*) from logged group by operation`
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(q, names.qualified(""), names.qualified("_change_log"), unchangedRow))
+	if err != nil {
+		return summary, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var operation string
+		var n int64
+		if err := rows.Scan(&operation, &n); err != nil {
+			return summary, err
+		}
+		switch operation {
+		case "insert":
+			summary.Inserted = n
+		case "update":
+			summary.Updated = n
+		case "delete":
+			summary.Deleted = n
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return summary, err
+	}
+
+	q = `
+	delete from %[1]s o
+	where o._effective_date in (select distinct _effective_date from cmsrvu_stage)
+	and not exists (select 1 from cmsrvu_stage n where %[2]s)`
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(q, names.qualified(""), unchangedRow)); err != nil {
+		return summary, err
+	}
+
+	q = `insert into %s select * from cmsrvu_stage on conflict do nothing`
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(q, names.qualified("")))
+	return summary, err
+}
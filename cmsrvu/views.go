@@ -0,0 +1,200 @@
+package cmsrvu
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// CreatePostgresViews installs the views consumers use instead of writing their own
+// window queries, all named after table:
+//   - <table>_as_of(date): a set returning function with the rows of the release in
+//     effect on a date, one row per hcpcs/modifier/variant
+//   - <table>_current: <table>_as_of(current_date)
+//   - <table>_national_payment: a materialized view of national facility and
+//     non-facility payment amounts (total rvu * conversion factor) for every release,
+//     kept up to date by RefreshPostgresViews. modifier_key is modifier_code with
+//     nulls as an empty string and _variant is 0 for rows loaded before it existed,
+//     for its unique index.
+//
+// The table must already exist. It is safe to call more than once.
+func (r RelativeValueUnits) CreatePostgresViews(ctx context.Context, db *sqlx.DB, schema, table string) error {
+	names, err := newTableNames(schema, table)
+	if err != nil {
+		return err
+	}
+	if err := dropStaleNationalPayment(ctx, db, names); err != nil {
+		return err
+	}
+	// %[1]s is the table, %[2]s the object being created, %[3]s the as of function and
+	// %[4]s the national payment index
+	queries := []struct{ q, suffix string }{
+		{`
+		create or replace function %[2]s(as_of date) returns setof %[1]s
+		language sql stable as $$
+			select distinct on (hcpcs, modifier_code, coalesce(_variant, 0)) *
+			from %[1]s
+			where _effective_date = (
+				select max(_effective_date) from %[1]s where _effective_date <= as_of
+			)
+			order by hcpcs, modifier_code, coalesce(_variant, 0), _extract_time desc
+		$$`, "_as_of"},
+		{`
+		create or replace view %[2]s as
+		select * from %[3]s(current_date)`, "_current"},
+		{`
+		create materialized view if not exists %[2]s as
+		select
+			_effective_date,
+			hcpcs,
+			modifier_code,
+			coalesce(modifier_code, '') as modifier_key,
+			coalesce(_variant, 0) as _variant,
+			description,
+			status_code,
+			conversion_factor,
+			total_facility_rvu,
+			total_nonfacility_rvu,
+			case when not facility_na_indicator
+				then round(total_facility_rvu * conversion_factor, 2)
+			end as facility_amount,
+			case when not nonfacility_na_indicator
+				then round(total_nonfacility_rvu * conversion_factor, 2)
+			end as nonfacility_amount
+		from (
+			select distinct on (_effective_date, hcpcs, modifier_code, coalesce(_variant, 0)) *
+			from %[1]s
+			order by _effective_date, hcpcs, modifier_code, coalesce(_variant, 0), _extract_time desc
+		) t`, "_national_payment"},
+		// required to refresh concurrently, it has to be on plain columns
+		{`
+		create unique index if not exists %[4]s
+		on %[2]s (_effective_date, hcpcs, modifier_key, _variant)`, "_national_payment"},
+	}
+	for _, q := range queries {
+		query := fmt.Sprintf(q.q,
+			names.qualified(""),
+			names.qualified(q.suffix),
+			names.qualified("_as_of"),
+			names.name("_national_payment_key_idx"),
+		)
+		if _, err := db.ExecContext(ctx, query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateSQLiteViews is the sqlite equivalent of CreatePostgresViews. Sqlite has neither
+// set returning functions nor materialized views, so it only creates:
+//   - <table>_current: the rows of the release in effect today, one row per
+//     hcpcs/modifier/variant as in postgres
+//   - <table>_national_payment: a plain view with the same columns as the postgres
+//     materialized view, so it's always up to date and there's nothing to refresh.
+//     Sqlite has no decimal arithmetic, so the amounts are rounded in floating
+//     point - RelativeValueUnit.NationalPayment is exact.
+//
+// For the rows in effect on another date use QueryRVUs with a DateOfService. The table
+// must already exist. It is safe to call more than once.
+func (r RelativeValueUnits) CreateSQLiteViews(ctx context.Context, db *sqlx.DB, table string) error {
+	names, err := newSQLiteTableNames(table)
+	if err != nil {
+		return err
+	}
+	// like the postgres views, if a release was loaded more than once only the rows of
+	// the latest extract are included. Sqlite has no distinct on, so it's the rows
+	// without a later extract of the same hcpcs/modifier/variant, rowid breaks ties.
+	latest := fmt.Sprintf(`not exists (
+			select 1 from %[1]s n
+			where n._effective_date = t._effective_date
+			and n.hcpcs = t.hcpcs
+			and n.modifier_code is t.modifier_code
+			and coalesce(n._variant, 0) = coalesce(t._variant, 0)
+			and (n._extract_time > t._extract_time
+				or n._extract_time = t._extract_time and n.rowid > t.rowid)
+		)`, names.qualified(""))
+	// %[1]s is the table, %[2]s the view being created and %[3]s the latest extract
+	// condition. Views are dropped first so older definitions are replaced.
+	queries := []struct{ q, suffix string }{
+		{`
+		drop view if exists %[2]s;
+		create view %[2]s as
+		select t.* from %[1]s t
+		where t._effective_date = (
+			select max(_effective_date) from %[1]s where _effective_date <= date('now')
+		)
+		and %[3]s`, "_current"},
+		{`
+		drop view if exists %[2]s;
+		create view %[2]s as
+		select
+			_effective_date,
+			hcpcs,
+			modifier_code,
+			coalesce(modifier_code, '') as modifier_key,
+			coalesce(_variant, 0) as _variant,
+			description,
+			status_code,
+			conversion_factor,
+			total_facility_rvu,
+			total_nonfacility_rvu,
+			case when not facility_na_indicator
+				then round(total_facility_rvu * conversion_factor, 2)
+			end as facility_amount,
+			case when not nonfacility_na_indicator
+				then round(total_nonfacility_rvu * conversion_factor, 2)
+			end as nonfacility_amount
+		from %[1]s t
+		where %[3]s`, "_national_payment"},
+	}
+	for _, q := range queries {
+		if _, err := db.ExecContext(ctx, fmt.Sprintf(q.q, names.qualified(""), names.qualified(q.suffix), latest)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nationalPaymentColumns are the columns of the national payment view that older
+// versions didn't have
+var nationalPaymentColumns = []string{"modifier_key", "_variant"}
+
+// dropStaleNationalPayment drops the national payment view if it was created without
+// one of nationalPaymentColumns, create materialized view if not exists would keep it
+// (and its index) as is. It's recreated with the current definition.
+func dropStaleNationalPayment(ctx context.Context, db *sqlx.DB, names tableNames) error {
+	view := names.qualified("_national_payment")
+	var stale bool
+	q := `
+	select to_regclass($1) is not null and (
+		select count(*) from pg_attribute
+		where attrelid = to_regclass($1) and attname = any($2) and not attisdropped
+	) < $3`
+	if err := db.QueryRowContext(ctx, q, view, pq.Array(nationalPaymentColumns), len(nationalPaymentColumns)).Scan(&stale); err != nil {
+		return err
+	}
+	if !stale {
+		return nil
+	}
+	_, err := db.ExecContext(ctx, "drop materialized view "+view)
+	return err
+}
+
+// RefreshPostgresViews refreshes the materialized views created by CreatePostgresViews,
+// it should be run after each load. Readers aren't blocked while it runs.
+func (r RelativeValueUnits) RefreshPostgresViews(ctx context.Context, db *sqlx.DB, schema, table string) error {
+	return refreshPostgresViews(ctx, db, schema, table)
+}
+
+// refreshPostgresViews is RefreshPostgresViews on a db or, to refresh in the same
+// transaction as the load, a tx
+func refreshPostgresViews(ctx context.Context, db sqlx.ExecerContext, schema, table string) error {
+	names, err := newTableNames(schema, table)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, "refresh materialized view concurrently "+names.qualified("_national_payment"))
+	return err
+}
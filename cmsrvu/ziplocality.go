@@ -0,0 +1,286 @@
+package cmsrvu
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jmoiron/sqlx"
+)
+
+// CMS publishes the ZIP code to carrier locality file quarterly, in its own archive
+// rather than with the rvus. It has two fixed width files: ZIP5 maps every ZIP code to
+// a contractor and locality, and ZIP9 lists the ZIP+4 ranges of the ZIP codes that are
+// split between localities, flagged in ZIP5.
+
+var (
+	// DefaultZIP5FileRegex matches the ZIP5 file in a ZIP code archive, ie ZIP5_JUL2024.txt
+	DefaultZIP5FileRegex = `(?i)^zip5.*\.txt$`
+	// DefaultZIP9FileRegex matches the ZIP9 file in a ZIP code archive, ie ZIP9_JUL2024.txt
+	DefaultZIP9FileRegex = `(?i)^zip9.*\.txt$`
+)
+
+// ZIPLocality is a line from the ZIP5 or ZIP9 file. ZIP5 lines have no PlusFour range.
+type ZIPLocality struct {
+	Source         string      `db:"_source"`
+	ExtractTime    time.Time   `db:"_extract_time"`
+	LastModified   time.Time   `db:"_last_modified"`
+	EffectiveDate  pgtype.Date `db:"_effective_date"`
+	State          string      `db:"state"`
+	ZIP            string      `db:"zip"`
+	PlusFourLow    string      `db:"plus_four_low"` // "" for ZIP5 lines
+	PlusFourHigh   string      `db:"plus_four_high"`
+	MAC            string      `db:"mac"`             // the carrier number
+	LocalityNumber string      `db:"locality_number"` // two digits, ie 01
+	RuralIndicator string      `db:"rural_indicator"` // blank, R (rural) or B (super rural)
+	// PlusFour is set on ZIP5 lines when the ZIP code is split between localities and
+	// has to be priced by its ZIP+4, see ZIP9
+	PlusFour bool `db:"plus_four"`
+}
+
+type ZIPLocalities []ZIPLocality
+
+var zipLocalityColumns = []string{
+	"_source",
+	"_extract_time",
+	"_last_modified",
+	"_effective_date",
+	"state",
+	"zip",
+	"plus_four_low",
+	"plus_four_high",
+	"mac",
+	"locality_number",
+	"rural_indicator",
+	"plus_four",
+}
+
+var zipLocalityKey = []string{"_effective_date", "zip", "plus_four_low"}
+
+// Locality returns the locality of zl
+func (zl ZIPLocality) Locality() Locality { return Locality{zl.MAC, zl.LocalityNumber} }
+
+// fixedField is a field of a fixed width file, positions are 1 based and inclusive as
+// in CMS's record layouts
+type fixedField struct {
+	from, to int
+}
+
+// in returns the field's value in line, short lines are padded with blanks
+func (f fixedField) in(line string) string {
+	if len(line) < f.from {
+		return ""
+	}
+	return strings.TrimSpace(line[f.from-1 : min(f.to, len(line))])
+}
+
+// the fields shared by the ZIP5 and ZIP9 record layouts
+var (
+	zipStateField    = fixedField{1, 2}
+	zipZIPField      = fixedField{3, 7}
+	zipCarrierField  = fixedField{8, 12}
+	zipLocalityField = fixedField{13, 14}
+	zipRuralField    = fixedField{15, 15}
+	zipPlusFourField = fixedField{21, 21}
+	zipPlusFourLow   = fixedField{22, 25} // ZIP9 only
+	zipPlusFourHigh  = fixedField{26, 29} // ZIP9 only
+)
+
+// FetchZIPLocalities downloads the ZIP code archive described by spec and parses its
+// ZIP5 and ZIP9 files, spec.FileRegex is ignored
+func FetchZIPLocalities(ctx context.Context, spec ReleaseSpec, opts ...Option) (ZIPLocalities, ReleaseMetadata, error) {
+	data, md, err := fetchArchive(ctx, spec, newFetchOptions(spec, opts))
+	if err != nil {
+		return nil, md, err
+	}
+	zls, err := ZIPLocalitiesFromZip(data, md)
+	return zls, md, err
+}
+
+// ZIPLocalitiesFromZip parses the ZIP5 and ZIP9 files from an archive that's already
+// been downloaded. Archives without a ZIP9 file only have ZIP5 lines.
+func ZIPLocalitiesFromZip(data []byte, md ReleaseMetadata) (ZIPLocalities, error) {
+	if !md.EffectiveDate.Valid {
+		return nil, errors.New("valid effectiveDate required")
+	}
+	zip5, err := LinesFromZip(data, DefaultZIP5FileRegex)
+	if err != nil {
+		return nil, err
+	}
+	zip9, err := LinesFromZip(data, DefaultZIP9FileRegex)
+	if err != nil && !errors.Is(err, errNoFileInArchive) {
+		return nil, err
+	}
+
+	zls := ZIPLocalities{}
+	for i, line := range zip5 {
+		zl, err := zipLocalityFromLine(line, md, false)
+		if err != nil {
+			return nil, fmt.Errorf("zip5 line %d: %w", i+1, err)
+		}
+		zls = append(zls, zl)
+	}
+	for i, line := range zip9 {
+		zl, err := zipLocalityFromLine(line, md, true)
+		if err != nil {
+			return nil, fmt.Errorf("zip9 line %d: %w", i+1, err)
+		}
+		zls = append(zls, zl)
+	}
+	return zls, nil
+}
+
+func zipLocalityFromLine(line string, md ReleaseMetadata, zip9 bool) (ZIPLocality, error) {
+	if len(line) < zipLocalityField.to {
+		return ZIPLocality{}, errors.New("line is too short")
+	}
+	zl := ZIPLocality{
+		Source:         md.Source,
+		ExtractTime:    md.ExtractTime,
+		LastModified:   md.LastModified,
+		EffectiveDate:  md.EffectiveDate,
+		State:          zipStateField.in(line),
+		ZIP:            zipZIPField.in(line),
+		MAC:            zipCarrierField.in(line),
+		LocalityNumber: localityNumber(zipLocalityField.in(line)),
+		RuralIndicator: zipRuralField.in(line),
+		PlusFour:       zipPlusFourField.in(line) == "1",
+	}
+	if !isDigits(zl.ZIP) || len(zl.ZIP) != 5 {
+		return zl, fmt.Errorf("invalid zip code %q", zl.ZIP)
+	}
+	if zip9 {
+		zl.PlusFourLow = zipPlusFourLow.in(line)
+		zl.PlusFourHigh = zipPlusFourHigh.in(line)
+		if !isDigits(zl.PlusFourLow) || !isDigits(zl.PlusFourHigh) {
+			return zl, fmt.Errorf("invalid zip+4 range %q-%q", zl.PlusFourLow, zl.PlusFourHigh)
+		}
+	}
+	return zl, nil
+}
+
+// CreateTable creates schema.table for ZIPLocalities in postgres or sqlite (schema is
+// ignored)
+func (zls ZIPLocalities) CreateTable(ctx context.Context, db *sqlx.DB, schema, table string) (sql.Result, error) {
+	names, err := dbTableNames(db, schema, table)
+	if err != nil {
+		return nil, err
+	}
+	q := `
+	create table if not exists %[1]s (
+		_source text,
+		_extract_time %[2]s,
+		_last_modified %[2]s,
+		_effective_date date not null,
+		state text,
+		zip text not null,
+		plus_four_low text not null,
+		plus_four_high text not null,
+		mac text not null,
+		locality_number text not null,
+		rural_indicator text,
+		plus_four boolean not null,
+		primary key (_effective_date, zip, plus_four_low)
+	)`
+	return createReferenceTable(ctx, db, names, q)
+}
+
+// Put writes zls to schema.table, replacing any ZIP code or ZIP+4 range already loaded
+// for the same release. It returns the number of rows written.
+func (zls ZIPLocalities) Put(ctx context.Context, db *sqlx.DB, schema, table string) (int64, error) {
+	names, err := dbTableNames(db, schema, table)
+	if err != nil {
+		return 0, err
+	}
+	return putReferenceRows(ctx, db, names, zipLocalityColumns, zipLocalityKey, zls)
+}
+
+// QueryZIPLocalities reads every row of schema.table, ie for NewZIPLocalityIndex. It
+// works with postgres and sqlite (schema is ignored).
+func QueryZIPLocalities(ctx context.Context, db *sqlx.DB, schema, table string) (ZIPLocalities, error) {
+	names, err := dbTableNames(db, schema, table)
+	if err != nil {
+		return nil, err
+	}
+	q := fmt.Sprintf(
+		"select %s from %s order by _effective_date, zip, plus_four_low",
+		strings.Join(zipLocalityColumns, ", "), names.qualified(""),
+	)
+	zls := ZIPLocalities{}
+	if err := db.SelectContext(ctx, &zls, q); err != nil {
+		return nil, err
+	}
+	return zls, nil
+}
+
+// ZIPLocalityIndex answers "which locality prices a claim from this ZIP code on this
+// date of service" from memory. It isn't modified after it's built, so it's safe for
+// concurrent use.
+type ZIPLocalityIndex struct {
+	releases []time.Time              // effective dates, sorted
+	zips     map[zipKey][]ZIPLocality // the ZIP5 line first, then ZIP9 ranges in order
+}
+
+type zipKey struct {
+	release string // effective date, yyyy-mm-dd
+	zip     string
+}
+
+// NewZIPLocalityIndex indexes zls, which can span any number of releases
+func NewZIPLocalityIndex(zls ZIPLocalities) *ZIPLocalityIndex {
+	x := &ZIPLocalityIndex{zips: map[zipKey][]ZIPLocality{}}
+	for _, zl := range zls {
+		key := zipKey{zl.EffectiveDate.Time.Format(time.DateOnly), zl.ZIP}
+		x.zips[key] = append(x.zips[key], zl)
+		x.releases = append(x.releases, zl.EffectiveDate.Time)
+	}
+	slices.SortFunc(x.releases, time.Time.Compare)
+	x.releases = slices.CompactFunc(x.releases, time.Time.Equal)
+	for _, lines := range x.zips {
+		slices.SortFunc(lines, func(a, b ZIPLocality) int {
+			return strings.Compare(a.PlusFourLow, b.PlusFourLow)
+		})
+	}
+	return x
+}
+
+// LocalityForZIP returns the ZIP5 or ZIP9 line that prices a claim from zip on
+// dateOfService, in the latest release effective on or before it. zip can be a ZIP
+// code or ZIP+4, with or without the hyphen. A ZIP+4 is only needed for ZIP codes
+// split between localities, without one the ZIP5 line is returned. ok is false if
+// there's no such release or the ZIP code isn't in it.
+func (x *ZIPLocalityIndex) LocalityForZIP(zip string, dateOfService time.Time) (zl ZIPLocality, ok bool) {
+	zip = strings.ReplaceAll(cleanString(zip), "-", "")
+	if len(zip) != 5 && len(zip) != 9 || !isDigits(zip) {
+		return zl, false
+	}
+	release, ok := releaseOn(x.releases, dateOfService)
+	if !ok {
+		return zl, false
+	}
+	lines := x.zips[zipKey{release.Format(time.DateOnly), zip[:5]}]
+	if len(lines) == 0 {
+		return zl, false
+	}
+	zl = lines[0]
+	if !zl.PlusFour || len(zip) != 9 {
+		return zl, zl.PlusFourLow == ""
+	}
+	plusFour := zip[5:]
+	for _, line := range lines[1:] {
+		if line.PlusFourLow <= plusFour && plusFour <= line.PlusFourHigh {
+			return line, true
+		}
+	}
+	return zl, true
+}
+
+// Releases returns the effective dates of the indexed releases, oldest first
+func (x *ZIPLocalityIndex) Releases() []time.Time {
+	return slices.Clone(x.releases)
+}
@@ -0,0 +1,71 @@
+// Package rvusnapshot embeds a snapshot of every release in cmsrvu.DefaultConfig so
+// tools can look up rvus with no network or database access.
+//
+// The snapshot is generated by the cmsrvu snapshot command, to refresh it:
+//
+//	go generate ./rvusnapshot
+//
+// Generating the snapshot downloads every release, the checked in rvu.parquet is an
+// empty placeholder until it has been run - until then RVUs, Index and Lookup return
+// ErrEmptySnapshot.
+package rvusnapshot
+
+import (
+	"bytes"
+	_ "embed"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/exiledavatar/cmsrvu/cmsrvu"
+)
+
+//go:generate go run .. snapshot --out rvu.parquet
+
+//go:embed rvu.parquet
+var snapshot []byte
+
+// ErrEmptySnapshot is returned when the embedded snapshot has no rows, ie it hasn't
+// been generated
+var ErrEmptySnapshot = errors.New("rvusnapshot: the embedded snapshot is empty, run go generate ./rvusnapshot")
+
+var (
+	once  sync.Once
+	index *cmsrvu.RVUIndex
+	err   error
+)
+
+// Index returns an index of the snapshot, it is built on first use
+func Index() (*cmsrvu.RVUIndex, error) {
+	once.Do(func() {
+		var rvus cmsrvu.RelativeValueUnits
+		rvus, err = RVUs()
+		if err == nil {
+			index = cmsrvu.NewRVUIndex(rvus)
+		}
+	})
+	return index, err
+}
+
+// RVUs returns every rvu in the snapshot
+func RVUs() (cmsrvu.RelativeValueUnits, error) {
+	rvus, err := cmsrvu.ReadParquet(bytes.NewReader(snapshot), int64(len(snapshot)))
+	if err != nil {
+		return nil, err
+	}
+	if len(rvus) == 0 {
+		return nil, ErrEmptySnapshot
+	}
+	return rvus, nil
+}
+
+// Lookup returns the rvus for hcpcs and modifier ("" for none) in effect on
+// dateOfService, see cmsrvu.RVUIndex.Lookup
+func Lookup(hcpcs, modifier string, dateOfService time.Time) (cmsrvu.RelativeValueUnit, bool, error) {
+	x, err := Index()
+	if err != nil {
+		return cmsrvu.RelativeValueUnit{}, false, err
+	}
+	rvu, ok := x.Lookup(hcpcs, modifier, dateOfService)
+	return rvu, ok, nil
+}